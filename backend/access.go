@@ -0,0 +1,94 @@
+package backend
+
+// The read policy for writs lives here, in one place.
+// CanViewWrit is the source of truth, visibilityFilter is its AQL twin
+// so that lists and pages can be filtered inside the db without
+// fetching writs the viewer may not see. Keep the two in lock step.
+//
+//   - admins see everything
+//   - writs that are not public are hidden (404) from everyone else
+//   - membersonly writs need a verified user (403)
+//   - writs with roles need a user holding every one of those roles (403)
+
+// CanViewWrit decide if a user (nil for anonymous viewers) may read a writ,
+// returns nil when they may, NoSuchWrit when it should stay hidden,
+// and ForbiddenWrit when it exists but they lack the rights to see it
+func CanViewWrit(w *Writ, user *User) error {
+	if user != nil && user.isAdmin() {
+		return nil
+	}
+
+	if !w.Public {
+		return NoSuchWrit
+	}
+
+	if w.MembersOnly && (user == nil || !user.Verified()) {
+		return ForbiddenWrit
+	}
+
+	if len(w.Roles) > 0 && (user == nil || !user.HasRoles(w.Roles)) {
+		return ForbiddenWrit
+	}
+
+	return nil
+}
+
+// RestrictTo make the query only return writs that user (nil for anonymous viewers) is allowed to read
+func (q *WritQuery) RestrictTo(user *User) {
+	q.restricted = true
+	q.viewer = user
+	q.IncludePrivate = true
+	q.IncludeMembersOnly = true
+	if user != nil {
+		q.Viewer = user.Key
+	}
+}
+
+// visibilityFilter the AQL version of CanViewWrit, adds bindvars to vars as needed
+func visibilityFilter(user *User, vars obj) string {
+	if user != nil && user.isAdmin() {
+		return ""
+	}
+
+	filter := `writ.public == true `
+
+	if user == nil || !user.Verified() {
+		filter += `&& writ.membersonly != true `
+	}
+
+	if user == nil {
+		filter += `&& LENGTH(writ.roles) == 0 `
+	} else {
+		vars["viewerroles"] = user.Roles
+		filter += `&& (LENGTH(writ.roles) == 0 || writ.roles ALL IN @viewerroles) `
+	}
+
+	return filter
+}
+
+// writAccessError figure out why a writ could not be read,
+// it's either ForbiddenWrit or NoSuchWrit, so the right page can be sent
+func writAccessError(slug, key string, user *User) error {
+	q := &WritQuery{
+		IncludePrivate:     true,
+		IncludeMembersOnly: true,
+		Omissions:          []string{"markdown", "content"},
+	}
+	if len(key) > 1 {
+		q.Key = key
+	} else {
+		q.Slug = slug
+	}
+
+	writ, err := Stores.Writs.QueryOne(q)
+	if err != nil {
+		return NoSuchWrit
+	}
+
+	err = CanViewWrit(&writ, user)
+	if err == nil {
+		// it's visible, so whatever went wrong it wasn't the policy
+		return NoSuchWrit
+	}
+	return err
+}
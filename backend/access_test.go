@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// patron a role only some users hold, for writs gated by roles
+const patron Role = 10
+
+var (
+	accessUsers = []struct {
+		name string
+		user *User
+	}{
+		{"anonymous", nil},
+		{"unverified", &User{Username: "newbie", Roles: []Role{UnverifiedUser}}},
+		{"member", &User{Username: "member", Roles: []Role{VerifiedUser}}},
+		{"patron", &User{Username: "patron", Roles: []Role{VerifiedUser, patron}}},
+		{"author", &User{Username: "author", Roles: []Role{VerifiedUser}}},
+		{"admin", &User{Username: "admin", Roles: []Role{VerifiedUser, Admin}}},
+	}
+
+	accessWrits = map[string]*Writ{
+		"public":      {Title: "public", Author: "author", Public: true, State: WritPublished},
+		"private":     {Title: "private", Author: "author", State: WritPublished},
+		"membersonly": {Title: "membersonly", Author: "author", Public: true, MembersOnly: true, State: WritPublished},
+		"rolegated":   {Title: "rolegated", Author: "author", Public: true, Roles: []Role{patron}, State: WritPublished},
+		"draft":       {Title: "draft", Author: "author", State: WritDraft},
+	}
+)
+
+// what each of accessUsers gets when reading a writ, in the same order
+var accessCases = []struct {
+	writ string
+	want []error
+}{
+	{"public", []error{nil, nil, nil, nil, nil, nil}},
+	{"private", []error{NoSuchWrit, NoSuchWrit, NoSuchWrit, NoSuchWrit, NoSuchWrit, nil}},
+	{"membersonly", []error{ForbiddenWrit, ForbiddenWrit, nil, nil, nil, nil}},
+	{"rolegated", []error{ForbiddenWrit, ForbiddenWrit, ForbiddenWrit, nil, ForbiddenWrit, nil}},
+	{"draft", []error{NoSuchWrit, NoSuchWrit, NoSuchWrit, NoSuchWrit, NoSuchWrit, nil}},
+}
+
+func TestCanViewWrit(t *testing.T) {
+	for _, tc := range accessCases {
+		for i, u := range accessUsers {
+			err := CanViewWrit(accessWrits[tc.writ], u.user)
+			if err != tc.want[i] {
+				t.Errorf("%s reading a %s writ: got %v, want %v", u.name, tc.writ, err, tc.want[i])
+			}
+		}
+	}
+}
+
+// the writs a reader can list and open through a restricted query on the embedded store,
+// which should be exactly the ones CanViewWrit lets them read
+func TestRestrictedQueries(t *testing.T) {
+	if err := setupMemoryStore(filepath.Join(t.TempDir(), "store.json")); err != nil {
+		t.Fatal(err)
+	}
+	for name, w := range accessWrits {
+		stored := *w
+		stored.Slug = name
+		if err := Stores.Writs.Create(&stored); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, u := range accessUsers {
+		q := &WritQuery{}
+		q.RestrictTo(u.user)
+		listed, err := Stores.Writs.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range accessCases {
+			want := tc.want[i] == nil
+			inList := false
+			for _, w := range listed {
+				inList = inList || w.Slug == tc.writ
+			}
+			if inList != want {
+				t.Errorf("%s listing writs: %s writ listed = %v, want %v", u.name, tc.writ, inList, want)
+			}
+
+			one := &WritQuery{Slug: tc.writ}
+			one.RestrictTo(u.user)
+			_, err := Stores.Writs.QueryOne(one)
+			if found := err == nil; found != want {
+				t.Errorf("%s opening a %s writ: found = %v (%v), want %v", u.name, tc.writ, found, err, want)
+			}
+		}
+	}
+}
@@ -1,12 +1,9 @@
 package backend
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"time"
-
-	"github.com/arangodb/go-driver"
 )
 
 // Role auth roles/perms
@@ -66,21 +63,44 @@ func (user *User) IsValid() bool {
 	return validUsernameAndEmail(user.Username, user.Email)
 }
 
-// Update update a user's details using a common map
-func (user *User) Update(query string, vars obj) error {
-	if len(user.Key) < 0 {
+// Update update a user's details using a common map, nil values remove fields
+func (user *User) Update(changes obj) error {
+	if len(user.Key) < 1 {
 		return ErrIncompleteUser
 	}
-	vars["key"] = user.Key
-	query = "FOR u in users FILTER u._key == @key UPDATE u WITH " + query + " IN users OPTIONS {keepNull: false, waitForSync: true} RETURN NEW"
-	ctx := driver.WithQueryCount(context.Background())
-	cursor, err := DB.Query(ctx, query, vars)
+	updated, err := Stores.Users.Update(user.Key, changes)
 	if err == nil {
-		defer cursor.Close()
-		_, err = cursor.ReadDocument(ctx, user)
-		if err != nil && DevMode {
-			fmt.Println("error updating user: ", err)
-		}
+		*user = updated
+	} else if DevMode {
+		fmt.Println("error updating user: ", err)
+	}
+	return err
+}
+
+// Append add values to one of a user's lists, like its sessions or roles, in one step
+func (user *User) Append(field string, values ...interface{}) error {
+	if len(user.Key) < 1 {
+		return ErrIncompleteUser
+	}
+	updated, err := Stores.Users.Append(user.Key, field, values...)
+	if err == nil {
+		*user = updated
+	} else if DevMode {
+		fmt.Println("error adding to a user's "+field+": ", err)
+	}
+	return err
+}
+
+// RemoveValues take values out of one of a user's lists in one step
+func (user *User) RemoveValues(field string, values ...interface{}) error {
+	if len(user.Key) < 1 {
+		return ErrIncompleteUser
+	}
+	updated, err := Stores.Users.RemoveValues(user.Key, field, values...)
+	if err == nil {
+		*user = updated
+	} else if DevMode {
+		fmt.Println("error removing from a user's "+field+": ", err)
 	}
 	return err
 }
@@ -121,16 +141,14 @@ func (user *User) isAdmin() bool {
 
 // SetupVerifier initiate verification process with verifier and db update
 func (user *User) SetupVerifier() error {
-	return user.Update("{verifier: @verifier}", obj{
+	return user.Update(obj{
 		"verifier": GenerateVerifier(user.Key),
 	})
 }
 
 // UserByKey retrieve user using their db document key
 func UserByKey(key string) (User, error) {
-	var user User
-	_, err := Users.ReadDocument(context.Background(), key, &user)
-	return user, err
+	return Stores.Users.ByKey(key)
 }
 
 // UserByUsername get user with a certain username
@@ -139,8 +157,7 @@ func UserByUsername(username string) (User, error) {
 	if !validUsername(username) {
 		return user, BadUsernameError
 	}
-	err := QueryOne(FindUSERByUsername, obj{"username": username}, &user)
-	return user, err
+	return Stores.Users.ByUsername(username)
 }
 
 // UserByEmail get user with a certain email
@@ -149,8 +166,7 @@ func UserByEmail(email string) (User, error) {
 	if !validEmail(email) {
 		return user, BadEmailError
 	}
-	err := QueryOne(FindUSERByEmail, obj{"email": email}, &user)
-	return user, err
+	return Stores.Users.ByEmail(email)
 }
 
 // UserByDetails attempt to get a user via their email/username combo
@@ -159,11 +175,7 @@ func UserByDetails(email, username string) (User, error) {
 	if !validEmail(email) || !validUsername(username) {
 		return user, InvalidDetailsError
 	}
-	err := QueryOne(FindUserByDetails, obj{
-		"email":    email,
-		"username": username,
-	}, &user)
-	return user, err
+	return Stores.Users.ByDetails(email, username)
 }
 
 // IsUsernameAvailable checks that the username is as of yet unused
@@ -187,15 +199,15 @@ func AuthenticateUser(email, username string) (User, error) {
 			return user, InvalidDetailsError
 		}
 
-		user = User{}
+		user = User{
+			Email:    email,
+			EmailMD5: GetMD5Hash(email),
+			Username: username,
+			Roles:    []Role{UnverifiedUser},
+			Created:  time.Now(),
+		}
 
-		err = QueryOne(CreateUser, obj{
-			"email":    email,
-			"emailmd5": GetMD5Hash(email),
-			"username": username,
-			"roles":    []Role{UnverifiedUser},
-			"created":  time.Now(),
-		}, &user)
+		err = Stores.Users.Create(&user)
 		if err != nil {
 			if DevMode {
 				fmt.Println("\nAutentication - error: ", err, "\nuser:\t\n", user, "\n\t")
@@ -290,16 +302,12 @@ func VerifyUser(verifier string) (*User, error) {
 		return user, UnauthorizedError
 	}
 
-	if user.Verified() {
-		err = user.Update(`{verifier: null}`, obj{})
-	} else {
-		err = user.Update(`{
-			verifier: null,
-			roles: PUSH(REMOVE_VALUE(u.roles, @unverified), @verified, true)
-		}`, obj{
-			"unverified": UnverifiedUser,
-			"verified":   VerifiedUser,
-		})
+	err = user.Update(obj{"verifier": nil})
+	if err == nil && !user.Verified() {
+		err = user.RemoveValues("roles", UnverifiedUser)
+		if err == nil {
+			err = user.Append("roles", VerifiedUser)
+		}
 	}
 
 	if err != nil && DevMode {
@@ -316,21 +324,21 @@ func GenerateAuthToken(user *User, renew bool) (string, error) {
 	if err != nil {
 		panic(err)
 	}
-	vars := obj{}
 
-	if len(user.Sessions) > 0 {
-		for i, session := range user.Sessions {
-			if session.Add(oneweek).After(now) {
-				user.Sessions = append(user.Sessions[:i], user.Sessions[i+1:]...)
-			}
+	stale := []interface{}{}
+	for _, session := range user.Sessions {
+		if session.Add(oneweek).After(now) {
+			stale = append(stale, session)
 		}
 	}
-	vars["sessions"] = append(user.Sessions, now)
-	if renew {
-		err = user.Update(`{sessions: @sessions}`, vars)
-	} else {
-		vars["now"] = now
-		err = user.Update(`{sessions: @sessions, logins: PUSH(u.logins, @now)}`, vars)
+	if len(stale) != 0 {
+		err = user.RemoveValues("sessions", stale...)
+	}
+	if err == nil {
+		err = user.Append("sessions", now)
+	}
+	if err == nil && !renew {
+		err = user.Append("logins", now)
 	}
 	return token, err
 }
@@ -351,14 +359,15 @@ func ValidateAuthToken(token string) (User, bool) {
 	// guilty until proven innocent here unfortunately
 	ok = false
 	now := time.Now()
-	for i, session := range user.Sessions {
+	stale := []interface{}{}
+	for _, session := range user.Sessions {
 		if session.Add(oneweek).After(now) {
-			user.Sessions = append(user.Sessions[:i], user.Sessions[i+1:]...)
+			stale = append(stale, session)
 		} else if time.Unix(tk.Timestamp, 0) == session {
 			ok = true
 		}
 	}
-	ok = user.Update(`{sessions: @sessions}`, obj{"sessions": user.Sessions}) == nil
+	ok = len(stale) == 0 || user.RemoveValues("sessions", stale...) == nil
 	return user, ok
 }
 
@@ -546,10 +555,7 @@ func initAuth() {
 					return
 				}
 
-				user.Update(
-					"{session: REMOVE_VALUE(u.sessions, @session)}",
-					obj{"session": time.Unix(tk.Timestamp, 0)},
-				)
+				user.RemoveValues("sessions", time.Unix(tk.Timestamp, 0))
 			}()
 		}
 		return nil
@@ -597,7 +603,7 @@ func initAuth() {
 	})
 
 	Server.GET("/subscribe-toggle", AuthHandle(func(c ctx, user *User) error {
-		err := user.Update("{subscriber: @subscriber}", obj{"subscriber": !user.Subscriber})
+		err := user.Update(obj{"subscriber": !user.Subscriber})
 		if err != nil {
 			mail := MakeEmail()
 			mail.To("saulvdw@gmail.com")
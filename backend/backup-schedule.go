@@ -0,0 +1,338 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupConfig when and where the server backs itself up, and how many backups it keeps
+type BackupConfig struct {
+	// Schedule a cron expression (minute hour day-of-month month day-of-week),
+	// or one of @hourly, @daily, @weekly and @monthly; backups are off when it's empty
+	Schedule string `json:"schedule,omitempty" toml:"schedule,omitempty"`
+	// Dir where backups go, Conf.Private/backups by default
+	Dir string `json:"dir,omitempty" toml:"dir,omitempty"`
+	// Daily, Weekly and Monthly how many of the newest backups from distinct days, weeks
+	// and months to keep, everything else is pruned; with none set it's 7, 4 and 6
+	Daily   int `json:"daily,omitempty" toml:"daily,omitempty"`
+	Weekly  int `json:"weekly,omitempty" toml:"weekly,omitempty"`
+	Monthly int `json:"monthly,omitempty" toml:"monthly,omitempty"`
+}
+
+// BackupScheduler fires when the next scheduled backup is due
+var BackupScheduler *time.Timer
+
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// cronSchedule a parsed cron expression, each field is a bitset of the values it matches
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// anyDom and anyDow whether the day fields were left as *,
+	// when both are restricted a day matching either will do, like cron
+	anyDom, anyDow bool
+}
+
+// parseCronField turn one field of a cron expression into a bitset of the values it matches
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i != -1 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step in %q", field)
+			}
+			step, part = n, part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value in %q", field)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad range in %q", field)
+				}
+			} else if step != 1 {
+				// 5/15 means from 5 onwards
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range, it goes from %d to %d", field, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// parseCron parse a five field cron expression or a shortcut like @daily
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if shortcut, ok := cronShortcuts[expr]; ok {
+		expr = shortcut
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("a schedule needs five fields: minute hour day-of-month month day-of-week")
+	}
+
+	s := &cronSchedule{anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.anyDom && s.anyDow:
+		return true
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next the first time after t the schedule fires, zero if it never does (like on february 30th)
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// backupFile a backup in the backups directory, and when it was taken going by its name
+type backupFile struct {
+	Path  string
+	Taken time.Time
+}
+
+// listBackups the backups in dir, newest first
+func listBackups(dir string) ([]backupFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []backupFile{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, "backup-") || !strings.HasSuffix(name, ".tar.gz") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, "backup-"), ".tar.gz")
+		taken, err := time.Parse("2006-01-02T150405", stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{Path: filepath.Join(dir, name), Taken: taken})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Taken.After(backups[j].Taken)
+	})
+	return backups, nil
+}
+
+// backupsToPrune the backups that fall outside the retention policy,
+// the newest backup of each of the last n days, weeks and months is kept
+func backupsToPrune(backups []backupFile, daily, weekly, monthly int) []backupFile {
+	keep := make([]bool, len(backups))
+	period := func(n int, bucket func(t time.Time) string) {
+		seen := map[string]bool{}
+		for i, backup := range backups {
+			if len(seen) == n {
+				return
+			}
+			b := bucket(backup.Taken)
+			if !seen[b] {
+				seen[b] = true
+				keep[i] = true
+			}
+		}
+	}
+
+	period(daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	period(weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return strconv.Itoa(year) + "-" + strconv.Itoa(week)
+	})
+	period(monthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	prune := []backupFile{}
+	for i, backup := range backups {
+		if !keep[i] {
+			prune = append(prune, backup)
+		}
+	}
+	return prune
+}
+
+// pruneBackups delete the backups the retention policy doesn't keep
+func pruneBackups(conf *BackupConfig) error {
+	backups, err := listBackups(conf.Dir)
+	if err != nil {
+		return err
+	}
+
+	daily, weekly, monthly := conf.Daily, conf.Weekly, conf.Monthly
+	if daily == 0 && weekly == 0 && monthly == 0 {
+		daily, weekly, monthly = 7, 4, 6
+	}
+
+	for _, backup := range backupsToPrune(backups, daily, weekly, monthly) {
+		if err = os.Remove(backup.Path); err != nil {
+			return err
+		}
+		if DevMode {
+			fmt.Println("backups: pruned ", backup.Path)
+		}
+	}
+	return nil
+}
+
+// scheduledBackup take a backup, make sure it reads back the same, then prune the old ones
+func scheduledBackup(conf *BackupConfig) (string, error) {
+	location := filepath.Join(conf.Dir, backupName(time.Now()))
+	written, err := BackupTo(location)
+	if err != nil {
+		return location, err
+	}
+
+	verified, err := VerifyBackup(location)
+	if err == nil {
+		for i, entry := range written.Collections {
+			if i >= len(verified.Collections) || verified.Collections[i] != entry {
+				err = errors.New("it doesn't match what was written")
+				break
+			}
+		}
+	}
+	if err != nil {
+		os.Remove(location)
+		return location, fmt.Errorf("the backup failed verification: %v", err)
+	}
+
+	return location, pruneBackups(conf)
+}
+
+// backupFailed let the maintainers know a scheduled backup didn't work out
+func backupFailed(location string, failure error) {
+	fmt.Println("backups: the scheduled backup failed - ", failure)
+
+	mail := MakeEmail()
+	mail.To(MaintainerEmails...)
+	mail.Subject(AppDomain + " scheduled backup failed")
+	mail.Plain().Set(
+		"The " + AppName + " server couldn't back itself up.\n\n" +
+			"\ttime: " + time.Now().Format(time.RFC1123) + "\n" +
+			"\tbackup: " + location + "\n" +
+			"\terror: " + failure.Error() + "\n\n" +
+			"The last good backup is still in " + filepath.Dir(location) + ", check on the server soon.\n\n" +
+			"Yours truly\nThe " + AppName + " Server.",
+	)
+	if err := SendEmail(mail); err != nil {
+		fmt.Println("backups: couldn't email the maintainers about it either - ", err)
+	}
+}
+
+func startBackupScheduler() {
+	conf := &Conf.Backups
+	if len(conf.Schedule) == 0 {
+		return
+	}
+	schedule, err := parseCron(conf.Schedule)
+	if err != nil {
+		fmt.Println("backups: the schedule is invalid, there won't be any - ", err)
+		return
+	}
+	if len(conf.Dir) == 0 {
+		conf.Dir = filepath.Join(Conf.Private, "backups")
+	}
+
+	next := schedule.Next(time.Now())
+	if next.IsZero() {
+		fmt.Println("backups: the schedule never comes around, there won't be any")
+		return
+	}
+
+	BackupScheduler = time.NewTimer(time.Until(next))
+	go func() {
+		for range BackupScheduler.C {
+			location, err := scheduledBackup(conf)
+			if err != nil {
+				backupFailed(location, err)
+			} else if DevMode {
+				fmt.Println("backups: backed up to ", location)
+			}
+
+			next = schedule.Next(time.Now())
+			if next.IsZero() {
+				return
+			}
+			BackupScheduler.Reset(time.Until(next))
+		}
+	}()
+	fmt.Println("backup scheduler started, the next backup is at ", next.Format(time.RFC1123))
+}
@@ -0,0 +1,496 @@
+package backend
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/integrii/flaggy"
+)
+
+const (
+	// BackupFormat what a backup's manifest calls its format
+	BackupFormat = "anend-backup"
+	// BackupVersion the newest version of the backup format, restores take it and anything older
+	BackupVersion = 1
+	// BackupManifestFile the first file in every backup archive
+	BackupManifestFile = "manifest.json"
+)
+
+// How a restore deals with documents whose keys are already taken
+const (
+	// RestoreSkip leave the stored document be
+	RestoreSkip = "skip"
+	// RestoreOverwrite replace the stored document with the backed up one
+	RestoreOverwrite = "overwrite"
+	// RestoreFail stop the restore there and then
+	RestoreFail = "fail"
+)
+
+var (
+	// ErrNoManifest the archive doesn't start with a manifest
+	ErrNoManifest = errors.New("that isn't a backup, there's no manifest at the start of it")
+	// ErrBadConflictMode the restore was asked to deal with conflicts in an unknown way
+	ErrBadConflictMode = errors.New("conflicts can only be skipped, overwritten or fail the restore")
+)
+
+// BackupCollection a collection's file in a backup, one json document per line
+type BackupCollection struct {
+	Name   string `json:"name" msgpack:"name"`
+	File   string `json:"file" msgpack:"file"`
+	Count  int64  `json:"count" msgpack:"count"`
+	SHA256 string `json:"sha256" msgpack:"sha256"`
+}
+
+// BackupManifest describes what's in a backup archive, it comes first in the archive
+type BackupManifest struct {
+	Format      string             `json:"format" msgpack:"format"`
+	Version     int                `json:"version" msgpack:"version"`
+	App         string             `json:"app" msgpack:"app"`
+	Storage     string             `json:"storage" msgpack:"storage"`
+	Created     time.Time          `json:"created" msgpack:"created"`
+	Collections []BackupCollection `json:"collections" msgpack:"collections"`
+}
+
+// RestoreTally what became of a collection's documents in a restore
+type RestoreTally struct {
+	Collection string `json:"collection" msgpack:"collection"`
+	Restored   int64  `json:"restored" msgpack:"restored"`
+	Replaced   int64  `json:"replaced" msgpack:"replaced"`
+	Skipped    int64  `json:"skipped" msgpack:"skipped"`
+	Failed     int64  `json:"failed" msgpack:"failed"`
+	// Errs the first few reasons documents failed
+	Errs []string `json:"errs,omitempty" msgpack:"errs,omitempty"`
+}
+
+// RestoreReport what a restore did
+type RestoreReport struct {
+	Manifest    *BackupManifest `json:"manifest" msgpack:"manifest"`
+	Collections []*RestoreTally `json:"collections" msgpack:"collections"`
+	// Ignored collections in the backup the store can't hold, like comments without arangodb
+	Ignored []string `json:"ignored,omitempty" msgpack:"ignored,omitempty"`
+}
+
+// storageName which sort of store the app is using, for manifests
+func storageName() string {
+	if usingArango() {
+		return "arangodb"
+	}
+	return "embedded"
+}
+
+// backupName a timestamped file name for a backup taken at t
+func backupName(t time.Time) string {
+	return "backup-" + t.UTC().Format("2006-01-02T150405") + ".tar.gz"
+}
+
+// dumpCollection write every document in a collection to a temporary file, a line each
+func dumpCollection(name string) (*os.File, *BackupCollection, error) {
+	f, err := ioutil.TempFile("", "backup-"+name+"-")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry := &BackupCollection{Name: name, File: name + ".jsonl"}
+	hash := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(f, hash))
+	err = Stores.Documents.Each(name, func(doc obj) error {
+		line, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		entry.Count++
+		w.Write(line)
+		return w.WriteByte('\n')
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return f, entry, nil
+}
+
+// WriteBackup write a gzipped tar of every collection the store holds to w,
+// the manifest goes first so restores can check what they're in for before touching anything
+func WriteBackup(w io.Writer) (*BackupManifest, error) {
+	manifest := &BackupManifest{
+		Format:      BackupFormat,
+		Version:     BackupVersion,
+		App:         AppName,
+		Storage:     storageName(),
+		Created:     time.Now().UTC(),
+		Collections: []BackupCollection{},
+	}
+
+	dumps := []*os.File{}
+	defer func() {
+		for _, f := range dumps {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	for _, name := range Stores.Documents.Collections() {
+		f, entry, err := dumpCollection(name)
+		if err != nil {
+			return manifest, fmt.Errorf("couldn't back up %s: %v", name, err)
+		}
+		dumps = append(dumps, f)
+		manifest.Collections = append(manifest.Collections, *entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = tw.WriteHeader(&tar.Header{
+		Name:    BackupManifestFile,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: manifest.Created,
+	})
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+
+	for i, f := range dumps {
+		if err != nil {
+			break
+		}
+		var info os.FileInfo
+		if info, err = f.Stat(); err != nil {
+			break
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:    manifest.Collections[i].File,
+			Mode:    0600,
+			Size:    info.Size(),
+			ModTime: manifest.Created,
+		})
+		if err == nil {
+			_, err = io.Copy(tw, f)
+		}
+	}
+
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	return manifest, err
+}
+
+// BackupTo write a backup to a file, it only appears there once it's complete
+func BackupTo(location string) (*BackupManifest, error) {
+	if err := os.MkdirAll(filepath.Dir(location), 0700); err != nil {
+		return nil, err
+	}
+
+	tmp := location + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := WriteBackup(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return manifest, err
+	}
+	return manifest, os.Rename(tmp, location)
+}
+
+// readBackup open a backup archive and check its manifest,
+// the returned reader is positioned just after the manifest
+func readBackup(r io.Reader) (*BackupManifest, *tar.Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, ErrNoManifest
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != BackupManifestFile {
+		return nil, nil, ErrNoManifest
+	}
+
+	manifest := &BackupManifest{}
+	if err = json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, nil, fmt.Errorf("the backup's manifest is unreadable: %v", err)
+	}
+	if manifest.Format != BackupFormat {
+		return nil, nil, fmt.Errorf("the backup is in an unknown format: %q", manifest.Format)
+	}
+	if manifest.Version < 1 || manifest.Version > BackupVersion {
+		return nil, nil, fmt.Errorf("the backup is version %d, only versions up to %d can be restored", manifest.Version, BackupVersion)
+	}
+
+	files := map[string]bool{}
+	for _, entry := range manifest.Collections {
+		if len(entry.Name) == 0 || len(entry.File) == 0 || files[entry.File] {
+			return nil, nil, errors.New("the backup's manifest lists a collection without a name or file, or a file twice")
+		}
+		files[entry.File] = true
+	}
+	return manifest, tr, nil
+}
+
+// entry the collection a file in a backup belongs to
+func (m *BackupManifest) entry(file string) *BackupCollection {
+	for i := range m.Collections {
+		if m.Collections[i].File == file {
+			return &m.Collections[i]
+		}
+	}
+	return nil
+}
+
+// eachLine call fn with every line in r, lines can be as long as they like
+func eachLine(r io.Reader, fn func(line []byte) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) != 0 {
+			if ferr := fn(line); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// VerifyBackup read a whole backup, checking every collection's file against the manifest
+func VerifyBackup(location string) (*BackupManifest, error) {
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	manifest, tr, err := readBackup(f)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return manifest, fmt.Errorf("the backup is damaged: %v", err)
+		}
+		entry := manifest.entry(header.Name)
+		if entry == nil {
+			return manifest, fmt.Errorf("the backup has a file its manifest doesn't mention: %s", header.Name)
+		}
+
+		hash := sha256.New()
+		var count int64
+		err = eachLine(io.TeeReader(tr, hash), func(line []byte) error {
+			if !json.Valid(line) {
+				return fmt.Errorf("document %d isn't valid json", count+1)
+			}
+			count++
+			return nil
+		})
+		if err != nil {
+			return manifest, fmt.Errorf("%s in the backup is damaged: %v", entry.File, err)
+		}
+		if count != entry.Count || hex.EncodeToString(hash.Sum(nil)) != entry.SHA256 {
+			return manifest, fmt.Errorf("%s in the backup doesn't match the manifest", entry.File)
+		}
+		seen[entry.File] = true
+	}
+
+	for _, entry := range manifest.Collections {
+		if !seen[entry.File] {
+			return manifest, fmt.Errorf("the backup is missing %s", entry.File)
+		}
+	}
+	return manifest, nil
+}
+
+// RestoreBackup put everything in a backup into the store, the backup is verified first
+// so a damaged one doesn't leave a half restored mess; onConflict is one of
+// RestoreSkip, RestoreOverwrite or RestoreFail, it decides what happens when keys are taken
+func RestoreBackup(location, onConflict string) (*RestoreReport, error) {
+	if onConflict != RestoreSkip && onConflict != RestoreOverwrite && onConflict != RestoreFail {
+		return nil, ErrBadConflictMode
+	}
+
+	manifest, err := VerifyBackup(location)
+	if err != nil {
+		return nil, err
+	}
+	report := &RestoreReport{Manifest: manifest, Collections: []*RestoreTally{}}
+
+	f, err := os.Open(location)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+	_, tr, err := readBackup(f)
+	if err != nil {
+		return report, err
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return report, nil
+		} else if err != nil {
+			return report, err
+		}
+		entry := manifest.entry(header.Name)
+		if !stringsContain(Stores.Documents.Collections(), entry.Name) {
+			report.Ignored = append(report.Ignored, entry.Name)
+			continue
+		}
+
+		tally := &RestoreTally{Collection: entry.Name}
+		report.Collections = append(report.Collections, tally)
+		err = eachLine(tr, func(line []byte) error {
+			doc := obj{}
+			decoder := json.NewDecoder(bytes.NewReader(line))
+			// big numbers, like unix nano timestamps, stay exact
+			decoder.UseNumber()
+			if err := decoder.Decode(&doc); err != nil {
+				return err
+			}
+
+			replaced, err := Stores.Documents.Put(entry.Name, doc, onConflict == RestoreOverwrite)
+			switch {
+			case err == ErrConflict && onConflict == RestoreFail:
+				return fmt.Errorf("document %v is already stored", doc["_key"])
+			case err == ErrConflict:
+				tally.Skipped++
+			case err != nil:
+				tally.Failed++
+				if len(tally.Errs) < 10 {
+					tally.Errs = append(tally.Errs, fmt.Sprintf("%v: %v", doc["_key"], err))
+				}
+			case replaced:
+				tally.Replaced++
+			default:
+				tally.Restored++
+			}
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("restoring %s stopped: %v", entry.Name, err)
+		}
+	}
+}
+
+func printRestoreReport(report *RestoreReport) {
+	if report == nil {
+		return
+	}
+	fmt.Printf("backup of %s (%s storage) from %s\n", report.Manifest.App, report.Manifest.Storage, report.Manifest.Created.Format(time.RFC1123))
+	for _, tally := range report.Collections {
+		fmt.Printf("%s: %d restored, %d replaced, %d skipped, %d failed\n", tally.Collection, tally.Restored, tally.Replaced, tally.Skipped, tally.Failed)
+		for _, err := range tally.Errs {
+			fmt.Println("\t", err)
+		}
+	}
+	for _, name := range report.Ignored {
+		fmt.Println(name + ": ignored, the store can't hold it")
+	}
+}
+
+func backupCommand() *Command {
+	var location string
+	sc := flaggy.NewSubcommand("backup")
+	sc.Description = "write a backup archive of every collection"
+	sc.AddPositionalValue(&location, "file", 1, false, "where the backup goes, it's put with the scheduled backups by default")
+
+	return &Command{
+		Subcommand: sc,
+		Run: func() error {
+			if len(location) == 0 {
+				dir := Conf.Backups.Dir
+				if len(dir) == 0 {
+					dir = filepath.Join(Conf.Private, "backups")
+				}
+				location = filepath.Join(dir, backupName(time.Now()))
+			}
+			manifest, err := BackupTo(location)
+			if err != nil {
+				return err
+			}
+			for _, entry := range manifest.Collections {
+				fmt.Printf("%s: %d documents\n", entry.Name, entry.Count)
+			}
+			fmt.Println("backed up to ", location)
+			return nil
+		},
+	}
+}
+
+func restoreCommand() *Command {
+	var location string
+	onConflict := RestoreSkip
+	sc := flaggy.NewSubcommand("restore")
+	sc.Description = "restore a backup archive, into an empty database or on top of what's there"
+	sc.AddPositionalValue(&location, "file", 1, true, "the backup to restore")
+	sc.String(&onConflict, "c", "on-conflict", "what to do with documents that are already stored: skip, overwrite or fail")
+
+	return &Command{
+		Subcommand: sc,
+		Run: func() error {
+			report, err := RestoreBackup(location, onConflict)
+			printRestoreReport(report)
+			return err
+		},
+	}
+}
+
+func initBackup() {
+	Server.GET("/backup", AdminHandle(func(c ctx, user *User) error {
+		res := c.Response()
+		res.Header().Set("Content-Type", "application/gzip")
+		res.Header().Set("Content-Disposition", `attachment; filename="`+backupName(time.Now())+`"`)
+
+		_, err := WriteBackup(res)
+		if err != nil {
+			fmt.Println("backup: couldn't send a backup to ", user.Username, " - ", err)
+			if !res.Committed {
+				return ServerDBError.Send(c)
+			}
+		}
+		return nil
+	}))
+
+	fmt.Println("Backup Service Started")
+}
@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/integrii/flaggy"
+	"github.com/logrusorgru/aurora"
+)
+
+// Command a cli subcommand, it runs instead of the server
+// once the config is digested and storage is set up
+type Command struct {
+	*flaggy.Subcommand
+	// Prepare runs before storage is set up, it's optional
+	Prepare func()
+	Run     func() error
+}
+
+// Commands every subcommand the app understands
+var Commands []*Command
+
+// setupCommands attach all the subcommands, before the flags are parsed
+func setupCommands() {
+	Commands = []*Command{
+		migrateCommand(),
+		exportCommand(),
+		importCommand(),
+		platformImportCommand("import-wordpress", "import the posts and pages of a wordpress export (wxr) file", ImportWordPress),
+		platformImportCommand("import-hugo", "import the content of a hugo site", ImportHugo),
+		platformImportCommand("import-jekyll", "import the posts and drafts of a jekyll site", ImportJekyll),
+		backupCommand(),
+		restoreCommand(),
+	}
+
+	for _, cmd := range Commands {
+		flaggy.AttachSubcommand(cmd.Subcommand, 1)
+	}
+}
+
+// usedCommand the subcommand the app was started with, if any
+func usedCommand() *Command {
+	for _, cmd := range Commands {
+		if cmd.Used {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// runCommand run the subcommand the app was started with,
+// it reports whether there was one, in which case the server shouldn't start
+func runCommand() bool {
+	cmd := usedCommand()
+	if cmd == nil {
+		return false
+	}
+
+	err := cmd.Run()
+	if err != nil {
+		fmt.Println(aurora.Red(cmd.Name+" failed: "), err)
+		os.Exit(1)
+	}
+	return true
+}
@@ -0,0 +1,224 @@
+package backend
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaxCommentDepth how deeply replies may be nested before they're flattened onto the deepest allowed parent
+const MaxCommentDepth = 8
+
+// Comment - struct representing a (threaded) comment on a writ
+type Comment struct {
+	Key       string      `json:"_key,omitempty" msgpack:"_key,omitempty"`
+	WritKey   string      `json:"writkey,omitempty" msgpack:"writkey,omitempty"`
+	Parent    string      `json:"parent,omitempty" msgpack:"parent,omitempty"`
+	Depth     int64       `json:"depth,omitempty" msgpack:"depth,omitempty"`
+	AuthorKey string      `json:"authorkey,omitempty" msgpack:"authorkey,omitempty"`
+	Author    string      `json:"author,omitempty" msgpack:"author,omitempty"`
+	Markdown  string      `json:"markdown,omitempty" msgpack:"markdown,omitempty"`
+	Content   string      `json:"content,omitempty" msgpack:"content,omitempty"`
+	Created   time.Time   `json:"created,omitempty" msgpack:"created,omitempty"`
+	Edits     []time.Time `json:"edits,omitempty" msgpack:"edits,omitempty"`
+	Deleted   bool        `json:"deleted,omitempty" msgpack:"deleted,omitempty"`
+	Children  []*Comment  `json:"children,omitempty" msgpack:"children,omitempty"`
+}
+
+// RenderContent from .Markdown generate sanitized html and set .Content
+func (cm *Comment) RenderContent() {
+	cm.Content = string(renderMarkdown([]byte(cm.Markdown), true)[:])
+}
+
+// CommentRequest for unmarshalling the post body of a new comment
+type CommentRequest struct {
+	Writ     string `json:"writ" msgpack:"writ"`
+	Parent   string `json:"parent,omitempty" msgpack:"parent,omitempty"`
+	Markdown string `json:"markdown" msgpack:"markdown"`
+}
+
+// CommentByKey retrieve a comment using its db document key
+func CommentByKey(key string) (Comment, error) {
+	return Stores.Comments.ByKey(key)
+}
+
+// CommentsByWrit get all of a writ's comments, oldest first
+func CommentsByWrit(writKey string) ([]Comment, error) {
+	return Stores.Comments.ByWrit(writKey)
+}
+
+// CommentTree get a writ's comments arranged as threads of replies
+func CommentTree(writKey string) ([]*Comment, int64, error) {
+	comments, err := CommentsByWrit(writKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	return threadComments(comments), int64(len(comments)), nil
+}
+
+// threadComments nests replies under their parents, orphans end up at the root
+func threadComments(comments []Comment) []*Comment {
+	byKey := make(map[string]*Comment, len(comments))
+	for i := range comments {
+		byKey[comments[i].Key] = &comments[i]
+	}
+
+	roots := []*Comment{}
+	for i := range comments {
+		comment := &comments[i]
+		parent, ok := byKey[comment.Parent]
+		if len(comment.Parent) == 0 || !ok {
+			roots = append(roots, comment)
+			continue
+		}
+		parent.Children = append(parent.Children, comment)
+	}
+	return roots
+}
+
+// CountComments count how many comments each of the given writs has
+func CountComments(writKeys []string) (map[string]int64, error) {
+	if len(writKeys) == 0 {
+		return map[string]int64{}, nil
+	}
+	return Stores.Comments.Count(writKeys)
+}
+
+// AddComment validate and store a new comment (or reply) on a writ
+func AddComment(user *User, req *CommentRequest) (Comment, error) {
+	var comment Comment
+	if len(req.Writ) == 0 || len(req.Markdown) == 0 {
+		return comment, BadRequestError
+	}
+	if len(req.Markdown) > 10000 {
+		return comment, CommentTooLongError
+	}
+
+	writ, err := WritByKey(req.Writ)
+	if err != nil {
+		return comment, NoSuchWrit
+	}
+	if err = CanViewWrit(&writ, user); err != nil {
+		return comment, err
+	}
+	if writ.NoComments {
+		return comment, CommentsDisabledError
+	}
+
+	comment = Comment{
+		WritKey:   writ.Key,
+		AuthorKey: user.Key,
+		Author:    user.Username,
+		Markdown:  req.Markdown,
+		Created:   time.Now(),
+	}
+
+	if len(req.Parent) != 0 {
+		parent, err := CommentByKey(req.Parent)
+		if err != nil || parent.WritKey != writ.Key {
+			return comment, NoSuchComment
+		}
+		if parent.Depth+1 > MaxCommentDepth {
+			comment.Parent = parent.Parent
+			comment.Depth = parent.Depth
+		} else {
+			comment.Parent = parent.Key
+			comment.Depth = parent.Depth + 1
+		}
+	}
+
+	comment.RenderContent()
+
+	err = Stores.Comments.Create(&comment)
+	if err != nil && DevMode {
+		fmt.Println("AddComment - creating a comment in the db: ", err)
+	}
+	return comment, err
+}
+
+// RemoveComment delete a comment, those with replies are blanked to keep their threads intact
+func RemoveComment(user *User, key string) error {
+	comment, err := CommentByKey(key)
+	if err != nil {
+		return NoSuchComment
+	}
+	if comment.AuthorKey != user.Key && !user.isAdmin() {
+		return UnauthorizedError
+	}
+
+	replies, err := Stores.Comments.HasReplies(key)
+	if err != nil {
+		return err
+	}
+	if !replies {
+		return Stores.Comments.Remove(key)
+	}
+
+	return Stores.Comments.Update(key, obj{
+		"markdown": "",
+		"content":  "<p><em>[deleted]</em></p>",
+		"deleted":  true,
+	})
+}
+
+// removeWritComments clear out all the comments belonging to a writ
+func removeWritComments(writKey string) error {
+	return Stores.Comments.RemoveWrit(writKey)
+}
+
+func initComments() {
+	Server.POST("/comment", AuthHandle(func(c ctx, user *User) error {
+		var req CommentRequest
+		err := c.Bind(&req)
+		if err != nil {
+			return BadRequestError.Send(c)
+		}
+
+		comment, err := AddComment(user, &req)
+		if err != nil {
+			if cr, ok := err.(*CodedResponse); ok {
+				return cr.Send(c)
+			}
+			return ServerDBError.Send(c)
+		}
+		return c.Msgpack(201, comment)
+	}))
+
+	Server.GET("/comments/:writ", AuthHandle(func(c ctx, user *User) error {
+		key := c.Param("writ")
+		if len(key) < 1 {
+			return BadRequestError.Send(c)
+		}
+
+		writ, err := WritByKey(key)
+		if err != nil {
+			return NoSuchWrit.Send(c)
+		}
+		if err = CanViewWrit(&writ, user); err != nil {
+			return err.(*CodedResponse).Send(c)
+		}
+
+		tree, count, err := CommentTree(writ.Key)
+		if err != nil {
+			return ServerDBError.Send(c)
+		}
+		return c.Msgpack(200, obj{"count": count, "comments": tree})
+	}))
+
+	Server.DELETE("/comment/:key", AuthHandle(func(c ctx, user *User) error {
+		key := c.Param("key")
+		if len(key) < 1 {
+			return BadRequestError.Send(c)
+		}
+
+		err := RemoveComment(user, key)
+		if err != nil {
+			if cr, ok := err.(*CodedResponse); ok {
+				return cr.Send(c)
+			}
+			return DeleteCommentError.Send(c)
+		}
+		return c.Msgpack(200, obj{"msg": "comment deleted"})
+	}))
+
+	fmt.Println("Comment Service Started")
+}
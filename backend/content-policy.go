@@ -0,0 +1,198 @@
+package backend
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"golang.org/x/net/html"
+)
+
+// ContentPolicyConfig what html writs and their injections may carry
+type ContentPolicyConfig struct {
+	// AdminElements raw html elements writs by admins may use on top of what everyone
+	// may, script, style, iframe or form for instance
+	AdminElements []string `json:"admin_elements,omitempty" toml:"admin_elements,omitempty"`
+	// AdminAttributes attributes writs by admins may put on any element, like style or src
+	AdminAttributes []string `json:"admin_attributes,omitempty" toml:"admin_attributes,omitempty"`
+	// ScriptSources where injections may load scripts from, either whole urls
+	// or prefixes ending in a slash like https://cdn.example.com/ or /js/
+	ScriptSources []string `json:"script_sources,omitempty" toml:"script_sources,omitempty"`
+	// StyleSources where injections may load stylesheets from, the same way as ScriptSources
+	StyleSources []string `json:"style_sources,omitempty" toml:"style_sources,omitempty"`
+}
+
+var (
+	classNames     = regexp.MustCompile(`^[\w -]+$`)
+	srcsetPattern  = regexp.MustCompile(`^[^\s,"'<>]+(\s+\d+[wx])?(,\s*[^\s,"'<>]+(\s+\d+[wx])?)*$`)
+	mediaSrc       = regexp.MustCompile(`^(https?://|/)[^\s"'<>]*$`)
+	youtubeEmbed   = regexp.MustCompile(`^https://www\.youtube-nocookie\.com/embed/[A-Za-z0-9_-]{11}$`)
+	numberPattern  = regexp.MustCompile(`^[0-9]+$`)
+	booleanPattern = func(name string) *regexp.Regexp {
+		return regexp.MustCompile(`^(|` + name + `|true)$`)
+	}
+)
+
+// allowPipelineOutput let through what the markdown pipeline and shortcodes put in writs
+func allowPipelineOutput(p *bluemonday.Policy) *bluemonday.Policy {
+	// prism's token classes, anchors, callouts, writ cards and the like
+	p.AllowAttrs("class").Matching(classNames).Globally()
+	p.AllowAttrs("aria-hidden").Matching(regexp.MustCompile(`^(true|false)$`)).OnElements("a")
+
+	// task lists
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("disabled").Matching(booleanPattern("disabled")).OnElements("input")
+	p.AllowAttrs("checked").Matching(booleanPattern("checked")).OnElements("input")
+
+	// responsive images
+	p.AllowElements("picture")
+	p.AllowAttrs("srcset").Matching(srcsetPattern).OnElements("img", "source")
+	p.AllowAttrs("sizes").Matching(bluemonday.Paragraph).OnElements("img", "source")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^[a-z]+/[a-z0-9.+-]+$`)).OnElements("source")
+	p.AllowAttrs("loading").Matching(regexp.MustCompile(`^(lazy|eager)$`)).OnElements("img", "iframe")
+
+	// video and audio players
+	p.AllowAttrs("src").Matching(mediaSrc).OnElements("video", "audio")
+	p.AllowAttrs("poster").Matching(mediaSrc).OnElements("video")
+	p.AllowAttrs("width", "height").Matching(numberPattern).OnElements("video")
+	p.AllowAttrs("controls").Matching(booleanPattern("controls")).OnElements("video", "audio")
+	p.AllowAttrs("preload").Matching(regexp.MustCompile(`^(none|metadata|auto)$`)).OnElements("video", "audio")
+
+	// youtube embeds, and nothing else in an iframe
+	p.AllowAttrs("src").Matching(youtubeEmbed).OnElements("iframe")
+	p.AllowAttrs("allow").Matching(regexp.MustCompile(`^[a-z; -]*$`)).OnElements("iframe")
+	p.AllowAttrs("allowfullscreen").Matching(booleanPattern("allowfullscreen")).OnElements("iframe")
+	return p
+}
+
+var (
+	// writPolicy sanitizes the writs of anyone who isn't an admin, it lets through
+	// what the markdown pipeline makes but no raw scripts or styles
+	writPolicy = allowPipelineOutput(bluemonday.UGCPolicy())
+
+	adminContentPolicy *bluemonday.Policy
+	adminPolicyOnce    sync.Once
+)
+
+// adminPolicy writPolicy plus the elements and attributes the config lets admins use
+func adminPolicy() *bluemonday.Policy {
+	adminPolicyOnce.Do(func() {
+		p := allowPipelineOutput(bluemonday.UGCPolicy())
+		if Conf != nil {
+			if elements := Conf.ContentPolicy.AdminElements; len(elements) != 0 {
+				p.AllowElements(elements...)
+				p.AllowNoAttrs().OnElements(elements...)
+				// bluemonday drops what's in a script or style, unless told otherwise
+				p.AllowElementsContent(elements...)
+			}
+			if attrs := Conf.ContentPolicy.AdminAttributes; len(attrs) != 0 {
+				p.AllowAttrs(attrs...).Globally()
+			}
+		}
+		adminContentPolicy = p
+	})
+	return adminContentPolicy
+}
+
+// contentPolicy the policy a writ's content is sanitized with, which depends on who wrote it
+func contentPolicy(author string) *bluemonday.Policy {
+	if len(author) != 0 {
+		user, err := UserByUsername(author)
+		if err == nil && user.isAdmin() {
+			return adminPolicy()
+		}
+	}
+	return writPolicy
+}
+
+// sourceAllowed whether a script or stylesheet url is one of, or under one of, the sources
+func sourceAllowed(src string, sources []string) bool {
+	if len(src) == 0 || strings.Contains(src, "..") || strings.Contains(src, `\`) {
+		return false
+	}
+	for _, source := range sources {
+		if src == source || (strings.HasSuffix(source, "/") && strings.HasPrefix(src, source)) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	injectionScriptAttrs = []string{"src", "async", "defer", "type", "integrity", "crossorigin", "nomodule", "referrerpolicy"}
+	injectionLinkAttrs   = []string{"rel", "href", "integrity", "crossorigin", "media", "referrerpolicy"}
+)
+
+// validInjectionTag check a <script> or <link> in an injection only loads from an allowed source
+func validInjectionTag(token html.Token) bool {
+	attrs := map[string]string{}
+	for _, attr := range token.Attr {
+		attrs[strings.ToLower(attr.Key)] = strings.TrimSpace(attr.Val)
+	}
+
+	var allowed []string
+	switch token.Data {
+	case "script":
+		if kind, ok := attrs["type"]; ok && kind != "module" && kind != "text/javascript" {
+			return false
+		}
+		if !sourceAllowed(attrs["src"], Conf.ContentPolicy.ScriptSources) {
+			return false
+		}
+		allowed = injectionScriptAttrs
+	case "link":
+		if strings.ToLower(attrs["rel"]) != "stylesheet" || !sourceAllowed(attrs["href"], Conf.ContentPolicy.StyleSources) {
+			return false
+		}
+		allowed = injectionLinkAttrs
+	default:
+		return false
+	}
+
+	for name := range attrs {
+		if !stringsContain(allowed, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateInjection make sure a writ's injection is nothing but <script src> and
+// <link rel="stylesheet"> tags loading from the sources the config allows
+func validateInjection(injection string) error {
+	if len(strings.TrimSpace(injection)) == 0 {
+		return nil
+	}
+	if Conf == nil {
+		return ErrInjectionNotAllowed
+	}
+
+	z := html.NewTokenizer(strings.NewReader(injection))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return ErrInjectionNotAllowed
+		case html.TextToken:
+			// inline scripts and stray text aren't allowed, only whitespace between the tags
+			if len(strings.TrimSpace(string(z.Text()))) != 0 {
+				return ErrInjectionNotAllowed
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if !validInjectionTag(z.Token()) {
+				return ErrInjectionNotAllowed
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) != "script" {
+				return ErrInjectionNotAllowed
+			}
+		case html.CommentToken:
+		default:
+			return ErrInjectionNotAllowed
+		}
+	}
+}
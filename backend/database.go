@@ -23,6 +23,16 @@ var (
 	Logs driver.Collection
 	// RateLimits arangodb ratelimits collection
 	RateLimits driver.Collection
+	// Comments arangodb comments collection containing comments on writs
+	Comments driver.Collection
+	// WritRevisions arangodb collection containing prior versions of writs
+	WritRevisions driver.Collection
+	// Redirects arangodb collection of old paths and the writs they lead to
+	Redirects driver.Collection
+	// WritSeries arangodb collection of ordered series of writs
+	WritSeries driver.Collection
+	// Media arangodb collection with the details of uploaded files
+	Media driver.Collection
 	// DBHealthTicker to see if the DB is still ok
 	DBHealthTicker *time.Ticker
 	// DBAlive does the db still live?
@@ -83,93 +93,44 @@ func setupDB(endpoints []string, dbname, username, password string) error {
 
 	DB = db
 
-	users, err := DB.Collection(nil, "users")
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			err = nil
-			users, err = DB.CreateCollection(nil, "users", &driver.CreateCollectionOptions{
-				WaitForSync: true,
-			})
-		}
-
-		if err != nil {
-			fmt.Println("Could not get users collection from db:", err)
-			return err
-		}
+	if !MigrateOnConnect {
+		// whoever turned it off is handling the migrations themselves
+		return nil
 	}
-	Users = users
 
-	writs, err := DB.Collection(nil, "writs")
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			err = nil
-			writs, err = DB.CreateCollection(nil, "writs", &driver.CreateCollectionOptions{})
-		}
-
-		if err != nil {
-			fmt.Println("Could not get writs collection from db:", err)
-			return err
-		}
-	}
-	Writs = writs
-
-	logs, err := DB.Collection(nil, "logs")
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			err = nil
-			logs, err = DB.CreateCollection(nil, "logs", &driver.CreateCollectionOptions{})
-		}
-
-		if err != nil {
-			fmt.Println("Could not get logs collection from db:", err)
-			return err
-		}
-	}
-	Logs = logs
-
-	_, _, err = Users.EnsureHashIndex(
-		nil,
-		[]string{"username", "email", "emailmd5"},
-		&driver.EnsureHashIndexOptions{Unique: true},
-	)
+	err = runMigrations(false)
 	if err != nil {
+		fmt.Println("Could not migrate the db:", err)
 		return err
 	}
 
-	_, _, err = Users.EnsureHashIndex(
-		nil,
-		[]string{"verifier"},
-		&driver.EnsureHashIndexOptions{Unique: true, Sparse: true},
-	)
-	if err != nil {
-		return err
-	}
+	err = bindCollections()
+	DBAlive = err == nil
+	return err
+}
 
-	_, _, err = Writs.EnsureHashIndex(
-		nil,
-		[]string{"title", "tags", "slug"},
-		&driver.EnsureHashIndexOptions{Unique: true},
-	)
-	if err != nil {
-		return err
+// bindCollections get hold of all the collections the app uses
+func bindCollections() error {
+	collections := map[string]*driver.Collection{
+		"users":          &Users,
+		"writs":          &Writs,
+		"logs":           &Logs,
+		"ratelimits":     &RateLimits,
+		"comments":       &Comments,
+		"writ_revisions": &WritRevisions,
+		"redirects":      &Redirects,
+		"series":         &WritSeries,
+		"media":          &Media,
 	}
-
-	ratelimits, err := DB.Collection(nil, "ratelimits")
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			err = nil
-			logs, err = DB.CreateCollection(nil, "ratelimits", &driver.CreateCollectionOptions{})
-		}
-
+	for name, collection := range collections {
+		coll, err := DB.Collection(nil, name)
 		if err != nil {
-			fmt.Println("Could not get ratelimiting collection from db:", err)
+			fmt.Println("Could not get "+name+" collection from db:", err)
 			return err
 		}
+		*collection = coll
 	}
-	RateLimits = ratelimits
-
-	DBAlive = err == nil
-	return err
+	return nil
 }
 
 var diedEmails = 0
@@ -234,16 +195,9 @@ func startDBHealthCheck() {
 				}
 			}
 			if len(LogQ) > 0 {
-				_, errs, err := Logs.CreateDocuments(nil, LogQ)
+				err := Stores.Logs.Add(LogQ)
 				if err != nil {
-					fmt.Println("log caching: db had trouble storing the current LogQ - ", err)
-				}
-				if len(errs) > 0 {
-					for _, err := range errs {
-						if err != nil {
-							fmt.Println("log caching error: ", err)
-						}
-					}
+					fmt.Println("log caching: had trouble storing the current LogQ - ", err)
 				}
 				LogQ = []LogEntry{}
 			}
@@ -254,6 +208,9 @@ func startDBHealthCheck() {
 
 // Query query the app's DB with AQL, bindvars, and map that to an output
 func Query(query string, vars obj) ([]obj, error) {
+	if !usingArango() {
+		return nil, ErrNeedsArangoDB
+	}
 	var objects []obj
 	ctx := driver.WithQueryCount(context.Background())
 	cursor, err := DB.Query(ctx, query, vars)
@@ -279,6 +236,9 @@ func Query(query string, vars obj) ([]obj, error) {
 
 // QueryOne query the app's DB with AQL, bindvars, and map that to an output
 func QueryOne(query string, vars obj, result interface{}) error {
+	if !usingArango() {
+		return ErrNeedsArangoDB
+	}
 	ctx := driver.WithQueryCount(context.Background())
 	cursor, err := DB.Query(ctx, query, vars)
 	if err == nil {
@@ -19,6 +19,12 @@ var (
 	ErrMissingTags = errors.New(`writ doesn't have any tags, add some`)
 	// ErrAuthorIsNoUser writ's author is persona non grata
 	ErrAuthorIsNoUser = errors.New(`writ author is not a registered user`)
+	// ErrInvalidWritState writ's state isn't one of draft, review, scheduled or published
+	ErrInvalidWritState = StaticErrorResponse(400, "writ state must be one of draft, review, scheduled or published")
+	// ErrInjectionNotAllowed writ's injection has something other than scripts and stylesheets from allowed sources
+	ErrInjectionNotAllowed = StaticErrorResponse(400, "a writ's injection may only load scripts and stylesheets from the allowed sources")
+	// ErrMissingPublishAt writ is scheduled but for when?
+	ErrMissingPublishAt = StaticErrorResponse(400, "a scheduled writ needs a publishat time")
 	// UnauthorizedError unauthorized request, cannot proceed
 	UnauthorizedError = StaticErrorResponse(403, "unauthorized request, cannot proceed")
 	// InvalidDetailsError invalid details, could not authorize user
@@ -47,8 +53,51 @@ var (
 	SuccessMsg = StaticResponse(203, "success!")
 	// DeleteWritError there was trouble when attempting to delete a writ, prolly database/bad-input related
 	DeleteWritError = StaticErrorResponse(500, "could not delete writ, maybe it didn't exist in the first place")
+	// ForbiddenWrit the writ exists but the viewer lacks the roles/membership to read it
+	ForbiddenWrit = StaticErrorResponse(403, "you don't have access to this writ")
+	// NoSuchRevision could not find a revision of that writ
+	NoSuchRevision = StaticErrorResponse(404, "couldn't find a revision like that for this writ")
+	// NoSuchComment could not find a comment matching the request
+	NoSuchComment = StaticErrorResponse(404, "couldn't find a comment like that")
+	// CommentsDisabledError the writ's author has turned off comments
+	CommentsDisabledError = StaticErrorResponse(403, "comments are disabled for this writ")
+	// CommentTooLongError somebody wrote an essay in the comments
+	CommentTooLongError = StaticErrorResponse(413, "comment is too long, keep it under 10000 characters")
+	// DeleteCommentError there was trouble when attempting to delete a comment
+	DeleteCommentError = StaticErrorResponse(500, "could not delete comment, maybe it didn't exist in the first place")
+	// SeriesNotFoundError there's no series with that key or slug
+	SeriesNotFoundError = StaticErrorResponse(404, "couldn't find a series like that")
+	// SeriesIncompleteError the series is missing its title
+	SeriesIncompleteError = StaticErrorResponse(400, "a series needs a title")
+	// SeriesBadWritsError the series lists writs that don't exist, or the same one twice
+	SeriesBadWritsError = StaticErrorResponse(400, "a series can only list writs that exist, and each just once")
+	// SeriesConflictError another series already has the title or slug
+	SeriesConflictError = StaticErrorResponse(409, "there's already a series with that title or slug")
+	// MediaNotFoundError there's no upload with that key
+	MediaNotFoundError = StaticErrorResponse(404, "couldn't find an upload like that")
+	// MediaEmptyError the uploaded file has nothing in it
+	MediaEmptyError = StaticErrorResponse(400, "the uploaded file is empty")
+	// MediaTooBigError the uploaded file is over MaxMediaSize
+	MediaTooBigError = StaticErrorResponse(413, "the uploaded file is too big, keep it under 32MB")
+	// MediaTypeError the uploaded file isn't an image, video, audio or pdf
+	MediaTypeError = StaticErrorResponse(415, "that kind of file can't be uploaded, only images, video, audio and pdfs")
+	// MediaMetadataError the metadata couldn't be stripped from an upload, so it isn't kept
+	MediaMetadataError = StaticErrorResponse(422, "the uploaded file seems to be damaged, its metadata couldn't be removed")
+	// MediaInUseError an upload can't be deleted while writs still link to it
+	MediaInUseError = StaticErrorResponse(409, "writs still link to this upload, it can't be deleted")
 )
 
+// WritConflictError a writ was saved from a stale revision, the response
+// carries the current server version so the editor can merge and retry
+func WritConflictError(current *Writ) *CodedResponse {
+	msg := "this writ was changed by someone else since you loaded it, merge their changes and try again"
+	return MakeCodedResponse(409, msg, obj{
+		"err":     msg,
+		"current": current.ToObj("content"),
+		"editrev": current.EditRev,
+	})
+}
+
 // PageError implements error but can send an .html file as a response
 type PageError struct {
 	Code    int
@@ -82,3 +131,6 @@ func MakePageErr(code int, value, path string) *PageError {
 
 // Err404NotFound is the standard 404 error response returned by Anend
 var Err404NotFound *PageError
+
+// Err403Forbidden is the standard 403 error response returned by Anend
+var Err403Forbidden *PageError
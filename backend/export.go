@@ -0,0 +1,304 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/integrii/flaggy"
+)
+
+// exportLinkRegexp matches href and src attributes pointing somewhere on the site,
+// either root relative or absolute, but not protocol relative (//cdn...)
+var exportLinkRegexp = regexp.MustCompile(`(href|src)="(https?://[^/"]+)?(/[^/"][^"]*|/)"`)
+
+// Exporter snapshots the public side of the site to plain files
+type Exporter struct {
+	// Dir where the export is written
+	Dir string
+	// Base the url the export will live at, absolute links in feeds,
+	// the sitemap and so on use it, it's left as the site's own url when empty
+	Base string
+
+	Writs int
+	Tags  int
+	Files int
+}
+
+// ExportSite write every public writ, tag page, feed, the sitemap and the assets to dir
+func ExportSite(dir, base string) (*Exporter, error) {
+	e := &Exporter{Dir: dir, Base: strings.TrimSuffix(base, "/")}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return e, err
+	}
+
+	if len(Conf.Assets) != 0 {
+		if err := e.copyAssets(Conf.Assets); err != nil {
+			return e, err
+		}
+	}
+
+	q := &WritQuery{Comments: true}
+	q.RestrictTo(nil)
+	writs, err := q.Exec()
+	if err != nil && !isNotFound(err) {
+		return e, err
+	}
+
+	tags := map[string][]Writ{}
+	for i := range writs {
+		writ := &writs[i]
+		if len(writ.Slug) == 0 || strings.ContainsAny(writ.Slug, `/\`) {
+			fmt.Println("export: skipping a writ with an unusable slug - ", writ.Title)
+			continue
+		}
+
+		page, err := Renderer.AsBytes("writ", writPageData(writ, nil))
+		if err != nil {
+			return e, fmt.Errorf("couldn't render %s: %v", writ.Slug, err)
+		}
+		if err = e.writePage("/writ/"+url.PathEscape(writ.Slug), page); err != nil {
+			return e, err
+		}
+		// the page's og:image points at the card, so it comes along
+		if card, err := WritOGImage(writ); err == nil {
+			if err = e.writeFile("/writ/"+url.PathEscape(writ.Slug)+"/og.png", card); err != nil {
+				return e, err
+			}
+		} else if DevMode {
+			fmt.Println("export: couldn't draw the card for ", writ.Slug, " - ", err)
+		}
+		e.Writs++
+
+		for _, tag := range writ.Tags {
+			tags[tag] = append(tags[tag], *writ)
+		}
+	}
+
+	if err = e.writeTags(tags); err != nil {
+		return e, err
+	}
+
+	for file, kind := range feedFormats {
+		feed, err := buildFeed(kind, &feedScope{Title: AppName})
+		if err != nil {
+			return e, err
+		}
+		if err = e.writeFile(file, e.rebase(feed.Body)); err != nil {
+			return e, err
+		}
+	}
+
+	sm, err := buildSitemap()
+	if err != nil {
+		return e, err
+	}
+	if sm.Index != nil {
+		if err = e.writeFile("/sitemap.xml", e.rebase(sm.Index)); err != nil {
+			return e, err
+		}
+		for i, part := range sm.Parts {
+			if err = e.writeFile("/sitemap/"+strconv.Itoa(i+1)+".xml", e.rebase(part)); err != nil {
+				return e, err
+			}
+		}
+	} else if err = e.writeFile("/sitemap.xml", e.rebase(sm.Parts[0])); err != nil {
+		return e, err
+	}
+
+	err = e.writeFile("/robots.txt", e.rebase(robotsTxt()))
+	return e, err
+}
+
+func (e *Exporter) writeTags(tags map[string][]Writ) error {
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+
+	for _, tag := range names {
+		if strings.ContainsAny(tag, `/\`) || tag == "." || tag == ".." {
+			fmt.Println("export: skipping a tag that can't be a directory - ", tag)
+			continue
+		}
+		dir := "/tag/" + url.PathEscape(tag)
+
+		page, err := Renderer.AsBytes("tag", tagPageData(tag, tags[tag]))
+		if err != nil {
+			return fmt.Errorf("couldn't render the %s tag page: %v", tag, err)
+		}
+		if err = e.writePage(dir, page); err != nil {
+			return err
+		}
+
+		for file, kind := range feedFormats {
+			feed, err := buildFeed(kind, &feedScope{
+				Title: AppName + " - " + tag,
+				Path:  dir,
+				Tag:   tag,
+			})
+			if err != nil {
+				return err
+			}
+			if err = e.writeFile(dir+file, e.rebase(feed.Body)); err != nil {
+				return err
+			}
+		}
+		e.Tags++
+	}
+	return nil
+}
+
+// copyAssets copy the static assets over as is, except for html which gets its links rewritten
+func (e *Exporter) copyAssets(assets string) error {
+	return filepath.Walk(assets, func(location string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(assets, location)
+		if err != nil {
+			return err
+		}
+		rel = "/" + filepath.ToSlash(rel)
+
+		if strings.HasSuffix(rel, ".html") {
+			page, err := ioutil.ReadFile(location)
+			if err != nil {
+				return err
+			}
+			return e.writeFile(rel, e.relink(rel, page))
+		}
+
+		src, err := os.Open(location)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		dest, err := e.create(rel)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dest, src)
+		if cerr := dest.Close(); err == nil {
+			err = cerr
+		}
+		e.Files++
+		return err
+	})
+}
+
+// exportFile where a site path ends up in the export, writ and tag pages
+// and anything else that isn't obviously a file become a directory with an index.html
+func exportFile(p string) string {
+	if p == "" || strings.HasSuffix(p, "/") {
+		return p + "index.html"
+	}
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) == 2 && (parts[0] == "writ" || parts[0] == "tag") || path.Ext(p) == "" {
+		return p + "/index.html"
+	}
+	return p
+}
+
+// relink rewrite the links in a page at (site) path from so they're relative to it,
+// that way the export works wherever it's put, even straight off the disk
+func (e *Exporter) relink(from string, page []byte) []byte {
+	depth := strings.Count(strings.TrimPrefix(exportFile(from), "/"), "/")
+	up := strings.Repeat("../", depth)
+	own := siteURL()
+
+	page = exportLinkRegexp.ReplaceAllFunc(page, func(match []byte) []byte {
+		parts := exportLinkRegexp.FindSubmatch(match)
+		attr, host, link := string(parts[1]), string(parts[2]), string(parts[3])
+		if len(host) != 0 && host != own && host != "https://"+Conf.Domain {
+			// it's someone else's
+			return match
+		}
+
+		fragment := ""
+		if i := strings.IndexByte(link, '#'); i != -1 {
+			link, fragment = link[:i], link[i:]
+		}
+		if i := strings.IndexByte(link, '?'); i != -1 {
+			link = link[:i]
+		}
+
+		return []byte(attr + `="` + up + strings.TrimPrefix(exportFile(link), "/") + fragment + `"`)
+	})
+	return e.rebase(page)
+}
+
+// rebase swap the site's own url for the export's base, if it has one
+func (e *Exporter) rebase(content []byte) []byte {
+	if len(e.Base) == 0 {
+		return content
+	}
+	content = []byte(strings.Replace(string(content), siteURL(), e.Base, -1))
+	return []byte(strings.Replace(string(content), "https://"+Conf.Domain, e.Base, -1))
+}
+
+// writePage relink and write out a page that lives at (site) path p
+func (e *Exporter) writePage(p string, page []byte) error {
+	return e.writeFile(exportFile(p), e.relink(p, page))
+}
+
+func (e *Exporter) writeFile(p string, content []byte) error {
+	f, err := e.create(p)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	e.Files++
+	return err
+}
+
+// create open a file in the export for writing, making its directories along the way,
+// p is a site path so it's unescaped, and it may never escape the export's directory
+func (e *Exporter) create(p string) (*os.File, error) {
+	unescaped, err := url.PathUnescape(p)
+	if err != nil {
+		return nil, err
+	}
+	location := filepath.Join(e.Dir, filepath.FromSlash(unescaped))
+	if rel, err := filepath.Rel(e.Dir, location); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("export: %s would land outside the export", p)
+	}
+	if err = os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(location)
+}
+
+func exportCommand() *Command {
+	var dir, base string
+	sc := flaggy.NewSubcommand("export")
+	sc.Description = "write a static copy of the public side of the site to a directory"
+	sc.AddPositionalValue(&dir, "dir", 1, true, "where the export goes")
+	sc.String(&base, "b", "base", "the url the export will be served from, used for feeds and the sitemap")
+
+	return &Command{
+		Subcommand: sc,
+		Run: func() error {
+			startTemplating()
+			e, err := ExportSite(dir, base)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("exported %d writs and %d tags, %d files in all, to %s\n", e.Writs, e.Tags, e.Files, dir)
+			return nil
+		},
+	}
+}
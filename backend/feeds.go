@@ -0,0 +1,400 @@
+package backend
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// FeedSize how many of the latest writs a feed carries
+const FeedSize = 50
+
+type cdata struct {
+	Text string `xml:",cdata"`
+}
+
+type rssFeed struct {
+	XMLName      xml.Name   `xml:"rss"`
+	Version      string     `xml:"version,attr"`
+	AtomNS       string     `xml:"xmlns:atom,attr"`
+	ContentNS    string     `xml:"xmlns:content,attr"`
+	DublinCoreNS string     `xml:"xmlns:dc,attr"`
+	Channel      rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	Creator     string   `xml:"dc:creator,omitempty"`
+	Description string   `xml:"description,omitempty"`
+	Content     cdata    `xml:"content:encoded"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Links      []atomLink     `xml:"link"`
+	Author     atomPerson     `xml:"author"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    atomText       `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	Summary       string           `json:"summary,omitempty"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// feedScope what a feed is about, everything, one tag or one author
+type feedScope struct {
+	Title  string
+	Path   string
+	Tag    string
+	Author string
+}
+
+type cachedFeed struct {
+	Body     []byte
+	ETag     string
+	Modified time.Time
+	Type     string
+}
+
+var (
+	feedCache     = map[string]*cachedFeed{}
+	feedCacheLock sync.RWMutex
+)
+
+// clearFeedCache drop all the cached feeds, they'll be rebuilt when next requested
+func clearFeedCache() {
+	feedCacheLock.Lock()
+	feedCache = map[string]*cachedFeed{}
+	feedCacheLock.Unlock()
+}
+
+// siteURL the https root of the app, without a trailing slash
+func siteURL() string {
+	return "https://" + AppDomain
+}
+
+// LastModified when the writ was last edited, or created if it never was
+func (w *Writ) LastModified() time.Time {
+	if len(w.Edits) != 0 {
+		return w.Edits[len(w.Edits)-1]
+	}
+	return w.Created
+}
+
+// feedWrits the latest writs anyone (even anonymous viewers) may read within a scope
+func feedWrits(scope *feedScope) ([]Writ, time.Time, error) {
+	q := &WritQuery{
+		Limit: []int64{0, FeedSize},
+	}
+	q.RestrictTo(nil)
+	if len(scope.Tag) != 0 {
+		q.Tags = []string{scope.Tag}
+	}
+	if len(scope.Author) != 0 {
+		q.Author = scope.Author
+	}
+
+	writs, err := q.Exec()
+	var updated time.Time
+	for i := range writs {
+		if modified := writs[i].LastModified(); modified.After(updated) {
+			updated = modified
+		}
+	}
+	if updated.IsZero() {
+		updated = StartupDate
+	}
+	return writs, updated, err
+}
+
+func buildRSS(scope *feedScope, writs []Writ, updated time.Time) ([]byte, error) {
+	feed := rssFeed{
+		Version:      "2.0",
+		AtomNS:       "http://www.w3.org/2005/Atom",
+		ContentNS:    "http://purl.org/rss/1.0/modules/content/",
+		DublinCoreNS: "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         scope.Title,
+			Link:          siteURL() + "/",
+			Description:   scope.Title,
+			LastBuildDate: updated.Format(time.RFC1123Z),
+			Self:          atomLink{Href: siteURL() + scope.Path + "/feed.xml", Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+
+	for _, writ := range writs {
+		link := writ.GetLink()
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       writ.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: false, Value: writ.Key},
+			Creator:     writ.Author,
+			Description: writ.Description,
+			Content:     cdata{Text: writ.Content},
+			PubDate:     writ.Created.Format(time.RFC1123Z),
+			Categories:  writ.Tags,
+		})
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	return append([]byte(xml.Header), out...), err
+}
+
+func buildAtom(scope *feedScope, writs []Writ, updated time.Time) ([]byte, error) {
+	feed := atomFeed{
+		Title:   scope.Title,
+		ID:      siteURL() + scope.Path + "/atom.xml",
+		Updated: updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: siteURL() + "/", Rel: "alternate", Type: "text/html"},
+			{Href: siteURL() + scope.Path + "/atom.xml", Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+
+	for _, writ := range writs {
+		link := writ.GetLink()
+		entry := atomEntry{
+			Title:     writ.Title,
+			ID:        link,
+			Updated:   writ.LastModified().Format(time.RFC3339),
+			Published: writ.Created.Format(time.RFC3339),
+			Links:     []atomLink{{Href: link, Rel: "alternate", Type: "text/html"}},
+			Author:    atomPerson{Name: writ.Author},
+			Content:   atomText{Type: "html", Body: writ.Content},
+		}
+		if len(writ.Description) != 0 {
+			entry.Summary = &atomText{Type: "text", Body: writ.Description}
+		}
+		for _, tag := range writ.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	return append([]byte(xml.Header), out...), err
+}
+
+func buildJSONFeed(scope *feedScope, writs []Writ, updated time.Time) ([]byte, error) {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       scope.Title,
+		HomePageURL: siteURL() + "/",
+		FeedURL:     siteURL() + scope.Path + "/feed.json",
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, writ := range writs {
+		item := jsonFeedItem{
+			ID:            writ.Key,
+			URL:           writ.GetLink(),
+			Title:         writ.Title,
+			ContentHTML:   writ.Content,
+			Summary:       writ.Description,
+			DatePublished: writ.Created.Format(time.RFC3339),
+			Tags:          writ.Tags,
+			Authors:       []jsonFeedAuthor{{Name: writ.Author}},
+		}
+		if len(writ.Edits) != 0 {
+			item.DateModified = writ.LastModified().Format(time.RFC3339)
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	return json.MarshalIndent(feed, "", "  ")
+}
+
+// buildFeed get the writs in scope and turn them into an rss, atom or json feed
+func buildFeed(kind string, scope *feedScope) (*cachedFeed, error) {
+	writs, updated, err := feedWrits(scope)
+	if err != nil && len(writs) == 0 {
+		return nil, err
+	}
+
+	feed := &cachedFeed{Modified: updated.UTC().Truncate(time.Second)}
+	switch kind {
+	case "rss":
+		feed.Type = "application/rss+xml; charset=utf-8"
+		feed.Body, err = buildRSS(scope, writs, updated)
+	case "atom":
+		feed.Type = "application/atom+xml; charset=utf-8"
+		feed.Body, err = buildAtom(scope, writs, updated)
+	default:
+		feed.Type = "application/feed+json; charset=utf-8"
+		feed.Body, err = buildJSONFeed(scope, writs, updated)
+	}
+	if err != nil {
+		return nil, err
+	}
+	feed.ETag = `"` + MD5Hash(feed.Body) + `"`
+	return feed, nil
+}
+
+// serveFeed send a feed, rebuilding it only if it isn't cached, and
+// answering with 304 Not Modified when the reader already has the latest version
+func serveFeed(c ctx, kind string, scope *feedScope) error {
+	path := c.Request().URL.Path
+
+	feedCacheLock.RLock()
+	feed, ok := feedCache[path]
+	feedCacheLock.RUnlock()
+
+	if !ok {
+		var err error
+		feed, err = buildFeed(kind, scope)
+		if err != nil {
+			if DevMode {
+				fmt.Println("feeds: couldn't build ", path, " - ", err)
+			}
+			return ServerDBError.Send(c)
+		}
+
+		feedCacheLock.Lock()
+		if len(feedCache) > 2048 {
+			// there's a feed per tag and author, don't let them pile up forever
+			feedCache = map[string]*cachedFeed{}
+		}
+		feedCache[path] = feed
+		feedCacheLock.Unlock()
+	}
+
+	return serveCached(c, feed.Body, feed.Type, feed.ETag, feed.Modified)
+}
+
+// serveCached send a prebuilt response with ETag/Last-Modified headers,
+// or a 304 if the client's copy is still fresh
+func serveCached(c ctx, body []byte, contentType, etag string, modified time.Time) error {
+	req := c.Request()
+	res := c.Response()
+	res.Header().Set("ETag", etag)
+	res.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	res.Header().Set("Cache-Control", "public, max-age=300")
+
+	if match := req.Header.Get("If-None-Match"); len(match) != 0 {
+		if match == etag {
+			return c.NoContent(304)
+		}
+	} else if since, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+		return c.NoContent(304)
+	}
+
+	return c.Blob(200, contentType, body)
+}
+
+// feedFormats the file each kind of feed is served as
+var feedFormats = map[string]string{
+	"/feed.xml":  "rss",
+	"/atom.xml":  "atom",
+	"/feed.json": "json",
+}
+
+func initFeeds() {
+	for file, kind := range feedFormats {
+		kind := kind
+
+		Server.GET(file, func(c ctx) error {
+			return serveFeed(c, kind, &feedScope{Title: AppName})
+		})
+
+		Server.GET("/tag/:tag"+file, func(c ctx) error {
+			tag, err := url.PathUnescape(c.Param("tag"))
+			if err != nil || len(tag) < 1 {
+				return BadRequestError.Send(c)
+			}
+			return serveFeed(c, kind, &feedScope{
+				Title: AppName + " - " + tag,
+				Path:  "/tag/" + url.PathEscape(tag),
+				Tag:   tag,
+			})
+		})
+
+		Server.GET("/author/:author"+file, func(c ctx) error {
+			author := c.Param("author")
+			if !validUsername(author) {
+				return BadRequestError.Send(c)
+			}
+			return serveFeed(c, kind, &feedScope{
+				Title:  AppName + " - " + author,
+				Path:   "/author/" + author,
+				Author: author,
+			})
+		})
+	}
+
+	fmt.Println("Feed Service Started")
+}
@@ -0,0 +1,226 @@
+package backend
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	markdownBlankLines  = regexp.MustCompile(`\n{3,}`)
+	markdownInlineSpace = regexp.MustCompile(`[ \t]+`)
+	markdownEscapable   = strings.NewReplacer(`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`)
+)
+
+// htmlToMarkdown convert html, the sort blogging platforms export, to markdown,
+// whatever doesn't have a markdown equivalent (tables, iframes...) is kept as html
+func htmlToMarkdown(source string) (string, error) {
+	nodes, err := html.ParseFragment(strings.NewReader(source), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	c := &markdownConverter{}
+	for _, node := range nodes {
+		c.node(node)
+	}
+
+	out := markdownBlankLines.ReplaceAllString(c.buf.String(), "\n\n")
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n", nil
+}
+
+type markdownConverter struct {
+	buf strings.Builder
+	// lists how deep into (nested) lists we are, and whether each is ordered
+	lists []bool
+	// counts the number of the current item in each ordered list
+	counts []int
+}
+
+func (c *markdownConverter) write(s string) {
+	c.buf.WriteString(s)
+}
+
+// block make sure what comes next starts a new block
+func (c *markdownConverter) block() {
+	c.write("\n\n")
+}
+
+func (c *markdownConverter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.node(child)
+	}
+}
+
+// inner the markdown of a node's children on their own, for wrapping up in something else
+func (c *markdownConverter) inner(n *html.Node) string {
+	sub := &markdownConverter{lists: c.lists, counts: c.counts}
+	sub.children(n)
+	return sub.buf.String()
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// raw keep a node as html
+func (c *markdownConverter) raw(n *html.Node) {
+	var b strings.Builder
+	html.Render(&b, n)
+	c.block()
+	c.write(b.String())
+	c.block()
+}
+
+func (c *markdownConverter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		// wordpress leaves paragraphs as blank lines in its html, keep them that way
+		text := markdownInlineSpace.ReplaceAllString(n.Data, " ")
+		c.write(markdownEscapable.Replace(text))
+		return
+	case html.CommentNode:
+		// <!--more--> and friends mean nothing here
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Figure:
+		c.block()
+		c.children(n)
+		c.block()
+	case atom.Br:
+		c.write("  \n")
+	case atom.Hr:
+		c.block()
+		c.write("---")
+		c.block()
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level, _ := strconv.Atoi(n.Data[1:])
+		c.block()
+		c.write(strings.Repeat("#", level) + " " + strings.TrimSpace(strings.Replace(c.inner(n), "\n", " ", -1)))
+		c.block()
+	case atom.Strong, atom.B:
+		c.wrap(n, "**")
+	case atom.Em, atom.I:
+		c.wrap(n, "_")
+	case atom.Del, atom.S, atom.Strike:
+		c.wrap(n, "~~")
+	case atom.Code:
+		code := strings.Replace(textContent(n), "`", "\\`", -1)
+		c.write("`" + code + "`")
+	case atom.Pre:
+		lang := ""
+		if code := n.FirstChild; code != nil && code.DataAtom == atom.Code {
+			for _, class := range strings.Fields(htmlAttr(code, "class")) {
+				if strings.HasPrefix(class, "language-") {
+					lang = strings.TrimPrefix(class, "language-")
+				}
+			}
+		}
+		c.block()
+		c.write("```" + lang + "\n" + strings.TrimRight(textContent(n), "\n") + "\n```")
+		c.block()
+	case atom.A:
+		href := htmlAttr(n, "href")
+		text := strings.TrimSpace(c.inner(n))
+		if len(href) == 0 {
+			c.write(text)
+			return
+		}
+		if title := htmlAttr(n, "title"); len(title) != 0 {
+			c.write("[" + text + "](" + href + ` "` + strings.Replace(title, `"`, `\"`, -1) + `")`)
+			return
+		}
+		c.write("[" + text + "](" + href + ")")
+	case atom.Img:
+		src := htmlAttr(n, "src")
+		if len(src) == 0 {
+			return
+		}
+		c.write("![" + markdownEscapable.Replace(htmlAttr(n, "alt")) + "](" + src + ")")
+	case atom.Ul, atom.Ol:
+		c.lists = append(c.lists, n.DataAtom == atom.Ol)
+		c.counts = append(c.counts, 0)
+		if len(c.lists) == 1 {
+			c.block()
+		}
+		c.children(n)
+		c.lists = c.lists[:len(c.lists)-1]
+		c.counts = c.counts[:len(c.counts)-1]
+		if len(c.lists) == 0 {
+			c.block()
+		}
+	case atom.Li:
+		depth := len(c.lists)
+		marker := "- "
+		if depth != 0 && c.lists[depth-1] {
+			c.counts[depth-1]++
+			marker = strconv.Itoa(c.counts[depth-1]) + ". "
+		}
+		// nested lists get indented along with the rest of the item they're in
+		item := strings.TrimSpace(markdownBlankLines.ReplaceAllString(c.inner(n), "\n"))
+		item = strings.Replace(item, "\n", "\n   ", -1)
+		c.write("\n" + marker + item)
+	case atom.Blockquote:
+		quote := strings.TrimSpace(c.inner(n))
+		c.block()
+		c.write("> " + strings.Replace(quote, "\n", "\n> ", -1))
+		c.block()
+	case atom.Script, atom.Style:
+		// no place for these in a writ's markdown
+	case atom.Table, atom.Iframe, atom.Video, atom.Audio, atom.Object, atom.Embed, atom.Dl:
+		c.raw(n)
+	default:
+		c.children(n)
+	}
+}
+
+// wrap put something like ** around a node's contents, keeping the surrounding spaces outside
+func (c *markdownConverter) wrap(n *html.Node, with string) {
+	text := c.inner(n)
+	trimmed := strings.TrimSpace(text)
+	if len(trimmed) == 0 {
+		c.write(text)
+		return
+	}
+	lead := text[:strings.Index(text, trimmed)]
+	trail := text[len(lead)+len(trimmed):]
+	c.write(lead + with + trimmed + with + trail)
+}
+
+// textContent all the text in and under a node, as is
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.DataAtom == atom.Br {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
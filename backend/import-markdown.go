@@ -0,0 +1,315 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/integrii/flaggy"
+	"gopkg.in/yaml.v2"
+)
+
+// MaxImportSize the biggest markdown file an import will take
+const MaxImportSize = 4 << 20
+
+// FrontMatter the details at the top of a markdown file, between --- (yaml) or +++ (toml) lines,
+// when public, membersonly or state are left out a reimported writ keeps what it had
+type FrontMatter struct {
+	Title       string    `yaml:"title" toml:"title"`
+	Slug        string    `yaml:"slug" toml:"slug"`
+	Author      string    `yaml:"author" toml:"author"`
+	Description string    `yaml:"description" toml:"description"`
+	Tags        []string  `yaml:"tags" toml:"tags"`
+	Created     time.Time `yaml:"created" toml:"created"`
+	Public      *bool     `yaml:"public" toml:"public"`
+	MembersOnly *bool     `yaml:"membersonly" toml:"membersonly"`
+	State       string    `yaml:"state" toml:"state"`
+}
+
+// ImportFailure a file that couldn't be imported, and why
+type ImportFailure struct {
+	File string `json:"file" msgpack:"file"`
+	Err  string `json:"err" msgpack:"err"`
+}
+
+// ImportReport what became of each file in an import
+type ImportReport struct {
+	Created []string        `json:"created" msgpack:"created"`
+	Updated []string        `json:"updated" msgpack:"updated"`
+	Failed  []ImportFailure `json:"failed" msgpack:"failed"`
+	// Skipped things that were deliberately left out, like attachments in a wordpress export
+	Skipped []string `json:"skipped,omitempty" msgpack:"skipped,omitempty"`
+}
+
+var (
+	// ErrNoFrontMatter the markdown doesn't start with front matter
+	ErrNoFrontMatter = errors.New("there's no front matter, it should start with a --- or +++ line")
+	// ErrUnclosedFrontMatter the front matter never ends
+	ErrUnclosedFrontMatter = errors.New("the front matter isn't closed")
+)
+
+// MakeImportReport an empty report, ready to be filled in
+func MakeImportReport() *ImportReport {
+	return &ImportReport{Created: []string{}, Updated: []string{}, Failed: []ImportFailure{}}
+}
+
+// Fail note down that a file couldn't be imported
+func (r *ImportReport) Fail(file string, err error) {
+	r.Failed = append(r.Failed, ImportFailure{File: file, Err: err.Error()})
+}
+
+// splitFrontMatter separate the front matter from the markdown,
+// the front matter's format is either "yaml" or "toml"
+func splitFrontMatter(data []byte) (format string, matter, markdown []byte, err error) {
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var fence string
+	switch {
+	case bytes.HasPrefix(data, []byte("---\n")):
+		format, fence = "yaml", "---"
+	case bytes.HasPrefix(data, []byte("+++\n")):
+		format, fence = "toml", "+++"
+	default:
+		return "", nil, nil, ErrNoFrontMatter
+	}
+
+	rest := data[len(fence)+1:]
+	end := bytes.Index(rest, []byte("\n"+fence+"\n"))
+	if end == -1 {
+		if !bytes.HasSuffix(rest, []byte("\n"+fence)) {
+			return "", nil, nil, ErrUnclosedFrontMatter
+		}
+		return format, rest[:len(rest)-len(fence)-1], []byte{}, nil
+	}
+	return format, rest[:end], bytes.TrimLeft(rest[end+len(fence)+2:], "\n"), nil
+}
+
+// parseFrontMatter decode front matter in the given format into v
+func parseFrontMatter(format string, matter []byte, v interface{}) error {
+	if format == "toml" {
+		return toml.Unmarshal(matter, v)
+	}
+	return yaml.Unmarshal(matter, v)
+}
+
+// MarkdownToWrit turn a markdown file with front matter into a writ, not yet stored,
+// the front matter comes back too so importWrit can tell what it left out
+func MarkdownToWrit(data []byte) (*Writ, *FrontMatter, error) {
+	format, matter, markdown, err := splitFrontMatter(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fm := &FrontMatter{}
+	if err = parseFrontMatter(format, matter, fm); err != nil {
+		return nil, nil, fmt.Errorf("the front matter is invalid: %v", err)
+	}
+
+	fm.Title = strings.TrimSpace(fm.Title)
+	if len(fm.Title) == 0 {
+		return nil, nil, errors.New("the front matter needs a title")
+	}
+	if len(bytes.TrimSpace(markdown)) == 0 {
+		return nil, nil, errors.New("there's no markdown after the front matter")
+	}
+
+	writ := &Writ{
+		Title:       fm.Title,
+		Slug:        fm.Slug,
+		Author:      fm.Author,
+		Description: fm.Description,
+		Tags:        fm.Tags,
+		Created:     fm.Created,
+		State:       strings.TrimSpace(fm.State),
+		Markdown:    string(markdown),
+	}
+	if fm.Public != nil {
+		writ.Public = *fm.Public
+	}
+	if fm.MembersOnly != nil {
+		writ.MembersOnly = *fm.MembersOnly
+	}
+	return writ, fm, nil
+}
+
+// keepLeftOut carry over what the front matter didn't say from the writ it's reimported over,
+// the state's kept by InitWrit as long as the visibility doesn't change
+func (fm *FrontMatter) keepLeftOut(writ, current *Writ) {
+	if fm.Public == nil {
+		writ.Public = current.Public
+	}
+	if fm.MembersOnly == nil {
+		writ.MembersOnly = current.MembersOnly
+	}
+}
+
+// importedWrit the writ an import with this title updates, whether it's public or not
+func importedWrit(title string) (Writ, error) {
+	return (&WritQuery{
+		EditorMode:         true,
+		IncludePrivate:     true,
+		IncludeMembersOnly: true,
+		Title:              title,
+	}).ExecOne()
+}
+
+// importWrit store a writ from an import, updating the one with the same title if there is one,
+// it reports whether it was created or updated; author is used when the writ doesn't have its own,
+// fm is the front matter the writ came from if it did, what it leaves out an update keeps
+func importWrit(writ *Writ, author string, fm *FrontMatter) (bool, error) {
+	if len(writ.Author) == 0 {
+		writ.Author = author
+	}
+	user, err := UserByUsername(writ.Author)
+	if err != nil {
+		return false, fmt.Errorf("the author %q isn't a user", writ.Author)
+	}
+	writ.AuthorKey = user.Key
+	writ.Editor = user.Username
+
+	current, err := importedWrit(writ.Title)
+	exists := err == nil
+	if exists {
+		// drafts and private writs are updated too, rather than clashing with a new copy
+		writ.Key = current.Key
+		if fm != nil {
+			fm.keepLeftOut(writ, &current)
+		}
+	}
+
+	err = InitWrit(writ)
+	if err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+// ImportMarkdown import a single markdown file into the report, name is only used for reporting
+func ImportMarkdown(name string, data []byte, author string, report *ImportReport) {
+	if len(data) > MaxImportSize {
+		report.Fail(name, errors.New("it's too big to import"))
+		return
+	}
+
+	writ, fm, err := MarkdownToWrit(data)
+	if err != nil {
+		report.Fail(name, err)
+		return
+	}
+
+	created, err := importWrit(writ, author, fm)
+	if err != nil {
+		report.Fail(name, err)
+	} else if created {
+		report.Created = append(report.Created, name)
+	} else {
+		report.Updated = append(report.Updated, name)
+	}
+}
+
+// ImportMarkdownDir import every .md file in a directory, and those in its subdirectories
+func ImportMarkdownDir(dir, author string) (*ImportReport, error) {
+	report := MakeImportReport()
+	err := filepath.Walk(dir, func(location string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(location), ".md") {
+			return nil
+		}
+
+		name, _ := filepath.Rel(dir, location)
+		data, err := ioutil.ReadFile(location)
+		if err != nil {
+			report.Fail(name, err)
+			return nil
+		}
+		ImportMarkdown(name, data, author, report)
+		return nil
+	})
+	return report, err
+}
+
+// printImportReport list what happened to every file in an import
+func printImportReport(report *ImportReport) {
+	for _, name := range report.Created {
+		fmt.Println("created: ", name)
+	}
+	for _, name := range report.Updated {
+		fmt.Println("updated: ", name)
+	}
+	for _, failure := range report.Failed {
+		fmt.Println("failed:  ", failure.File, " - ", failure.Err)
+	}
+	for _, name := range report.Skipped {
+		fmt.Println("skipped: ", name)
+	}
+	fmt.Printf("\n%d created, %d updated, %d failed, %d skipped\n", len(report.Created), len(report.Updated), len(report.Failed), len(report.Skipped))
+}
+
+func importCommand() *Command {
+	var dir, author string
+	sc := flaggy.NewSubcommand("import")
+	sc.Description = "import a directory of markdown files with front matter as writs"
+	sc.AddPositionalValue(&dir, "dir", 1, true, "the directory to import from")
+	sc.String(&author, "a", "author", "the author of writs that don't name one themselves")
+
+	return &Command{
+		Subcommand: sc,
+		Run: func() error {
+			report, err := ImportMarkdownDir(dir, author)
+			printImportReport(report)
+			return err
+		},
+	}
+}
+
+func initImport() {
+	Server.POST("/import/markdown", AdminHandle(func(c ctx, user *User) error {
+		form, err := c.MultipartForm()
+		if err != nil {
+			return BadRequestError.Send(c)
+		}
+
+		files := form.File["files"]
+		if len(files) == 0 {
+			return BadRequestError.Send(c)
+		}
+
+		author := c.FormValue("author")
+		if len(author) == 0 {
+			author = user.Username
+		}
+
+		report := MakeImportReport()
+		for _, fh := range files {
+			if fh.Size > MaxImportSize {
+				report.Fail(fh.Filename, errors.New("it's too big to import"))
+				continue
+			}
+			f, err := fh.Open()
+			if err != nil {
+				report.Fail(fh.Filename, err)
+				continue
+			}
+			data, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				report.Fail(fh.Filename, err)
+				continue
+			}
+			ImportMarkdown(fh.Filename, data, author, report)
+		}
+
+		return c.Msgpack(200, report)
+	}))
+
+	fmt.Println("Import Service Started")
+}
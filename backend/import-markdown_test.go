@@ -0,0 +1,34 @@
+package backend
+
+import "testing"
+
+func TestReimportKeepsVisibility(t *testing.T) {
+	setupTestStore(t)
+	report := MakeImportReport()
+	imports := []struct {
+		markdown            string
+		public, membersonly bool
+	}{
+		{"---\ntitle: Hello\ntags: [x]\npublic: true\nmembersonly: true\n---\nfirst\n", true, true},
+		// leaving them out keeps what the writ had
+		{"---\ntitle: Hello\ntags: [x]\n---\nsecond\n", true, true},
+		{"---\ntitle: Hello\ntags: [x]\npublic: false\n---\nthird\n", false, true},
+	}
+	for i, imp := range imports {
+		ImportMarkdown("hello.md", []byte(imp.markdown), "author", report)
+		if len(report.Failed) != 0 {
+			t.Fatalf("import %d failed: %v", i, report.Failed)
+		}
+		writ, err := importedWrit("Hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if writ.Public != imp.public || writ.MembersOnly != imp.membersonly {
+			t.Errorf("after import %d public, membersonly = %v, %v, want %v, %v",
+				i, writ.Public, writ.MembersOnly, imp.public, imp.membersonly)
+		}
+	}
+	if len(report.Created) != 1 || len(report.Updated) != 2 {
+		t.Errorf("want 1 created and 2 updated, got %v", report)
+	}
+}
@@ -0,0 +1,468 @@
+package backend
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/integrii/flaggy"
+)
+
+// DefaultImportTag the tag writs get when whatever they're imported from didn't have any
+const DefaultImportTag = "uncategorized"
+
+var jekyllPostName = regexp.MustCompile(`^(\d{4})-(\d{2})-(\d{2})-(.+)$`)
+
+// looseTimeLayouts the ways dates tend to be written down in front matter and exports
+var looseTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+func parseLooseTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range looseTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// wxrExport the parts of a WordPress export (WXR) worth importing
+type wxrExport struct {
+	Channel struct {
+		Items []wxrItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type wxrItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	PubDate     string        `xml:"pubDate"`
+	Creator     string        `xml:"creator"`
+	Encoded     []wxrEncoded  `xml:"encoded"`
+	PostDate    string        `xml:"post_date"`
+	PostDateGMT string        `xml:"post_date_gmt"`
+	PostName    string        `xml:"post_name"`
+	Status      string        `xml:"status"`
+	PostType    string        `xml:"post_type"`
+	Categories  []wxrCategory `xml:"category"`
+}
+
+// wxrEncoded content:encoded and excerpt:encoded, told apart by their namespace
+type wxrEncoded struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type wxrCategory struct {
+	Domain string `xml:"domain,attr"`
+	Name   string `xml:",chardata"`
+}
+
+// wxrToWrit turn a WordPress post or page into a writ, not yet stored
+func wxrToWrit(item *wxrItem) (*Writ, error) {
+	writ := &Writ{
+		Title:  strings.TrimSpace(item.Title),
+		Slug:   item.PostName,
+		Author: item.Creator,
+	}
+	if len(writ.Title) == 0 {
+		return nil, errors.New("it doesn't have a title")
+	}
+
+	var content, excerpt string
+	for _, encoded := range item.Encoded {
+		if strings.Contains(encoded.XMLName.Space, "excerpt") {
+			excerpt = encoded.Value
+		} else {
+			content = encoded.Value
+		}
+	}
+	markdown, err := htmlToMarkdown(content)
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.TrimSpace(markdown)) == 0 {
+		return nil, errors.New("it doesn't have any content")
+	}
+	writ.Markdown = markdown
+	writ.Description = plainText(excerpt)
+
+	if t, ok := parseLooseTime(item.PostDateGMT); ok {
+		writ.Created = t
+	} else if t, ok := parseLooseTime(item.PubDate); ok {
+		writ.Created = t
+	} else if t, ok := parseLooseTime(item.PostDate); ok {
+		writ.Created = t
+	}
+
+	for _, category := range item.Categories {
+		name := strings.TrimSpace(category.Name)
+		if len(name) != 0 && (category.Domain == "category" || category.Domain == "post_tag") {
+			writ.Tags = appendUnique(writ.Tags, name)
+		}
+	}
+
+	switch item.Status {
+	case "publish":
+		writ.State = WritPublished
+	case "future":
+		writ.State = WritScheduled
+		writ.PublishAt = writ.Created
+	default:
+		writ.State = WritDraft
+	}
+
+	if link, err := url.Parse(item.Link); err == nil && len(link.Path) > 1 {
+		writ.Aliases = []string{link.Path}
+	}
+	return writ, nil
+}
+
+// ImportWordPress import the posts and pages in a WordPress export file (WXR)
+func ImportWordPress(location, author string) (*ImportReport, error) {
+	report := MakeImportReport()
+
+	f, err := os.Open(location)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+
+	var export wxrExport
+	if err = xml.NewDecoder(f).Decode(&export); err != nil {
+		return report, fmt.Errorf("%s isn't a readable WordPress export: %v", location, err)
+	}
+
+	for i := range export.Channel.Items {
+		item := &export.Channel.Items[i]
+		name := item.PostType + ": " + item.Title
+		if item.PostType != "post" && item.PostType != "page" {
+			report.Skipped = append(report.Skipped, name)
+			continue
+		}
+
+		writ, err := wxrToWrit(item)
+		if err != nil {
+			report.Fail(name, err)
+			continue
+		}
+		importMigrated(name, writ, author, report)
+	}
+	return report, nil
+}
+
+// frontMatterMap the front matter of a markdown file as a map, and the markdown after it
+func frontMatterMap(data []byte) (obj, []byte, error) {
+	format, matter, markdown, err := splitFrontMatter(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := obj{}
+	if err = parseFrontMatter(format, matter, &m); err != nil {
+		return nil, nil, fmt.Errorf("the front matter is invalid: %v", err)
+	}
+	return m, markdown, nil
+}
+
+func fmString(m obj, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := m[key]; ok && value != nil {
+			if s := strings.TrimSpace(fmt.Sprint(value)); len(s) != 0 {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// fmStrings a list from front matter, jekyll lets lists be written as space separated strings
+func fmStrings(m obj, keys ...string) []string {
+	list := []string{}
+	for _, key := range keys {
+		switch value := m[key].(type) {
+		case string:
+			for _, s := range strings.Fields(value) {
+				list = appendUnique(list, s)
+			}
+		case []interface{}:
+			for _, v := range value {
+				if s := strings.TrimSpace(fmt.Sprint(v)); len(s) != 0 {
+					list = appendUnique(list, s)
+				}
+			}
+		}
+	}
+	return list
+}
+
+func fmBool(m obj, key string) (bool, bool) {
+	value, ok := m[key].(bool)
+	return value, ok
+}
+
+func fmTime(m obj, keys ...string) time.Time {
+	for _, key := range keys {
+		switch value := m[key].(type) {
+		case time.Time:
+			return value
+		case string:
+			if t, ok := parseLooseTime(value); ok {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, item := range list {
+		if item == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// siteTreeWrit the parts of a hugo or jekyll content file that both have in common
+func siteTreeWrit(data []byte, ext string) (*Writ, obj, error) {
+	m, body, err := frontMatterMap(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	writ := &Writ{
+		Title:       fmString(m, "title"),
+		Slug:        fmString(m, "slug"),
+		Author:      fmString(m, "author"),
+		Description: fmString(m, "description", "summary", "excerpt"),
+		Tags:        fmStrings(m, "tags", "categories", "category"),
+		Created:     fmTime(m, "date", "publishDate", "publishdate"),
+		Markdown:    string(body),
+	}
+	if len(writ.Title) == 0 {
+		return nil, nil, errors.New("the front matter needs a title")
+	}
+
+	if ext == ".html" || ext == ".htm" {
+		if writ.Markdown, err = htmlToMarkdown(writ.Markdown); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(strings.TrimSpace(writ.Markdown)) == 0 {
+		return nil, nil, errors.New("there's nothing after the front matter")
+	}
+	return writ, m, nil
+}
+
+// siteTreeFiles every content file under dir, by its path relative to dir, in lexical order
+func siteTreeFiles(dir string, visit func(rel string, data []byte) error) error {
+	return filepath.Walk(dir, func(location string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(location)) {
+		case ".md", ".markdown", ".html", ".htm":
+		default:
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, location)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(location)
+		if err != nil {
+			return err
+		}
+		return visit(filepath.ToSlash(rel), data)
+	})
+}
+
+// ImportHugo import the content of a hugo site, dir can be the site or its content directory
+func ImportHugo(dir, author string) (*ImportReport, error) {
+	report := MakeImportReport()
+	if info, err := os.Stat(filepath.Join(dir, "content")); err == nil && info.IsDir() {
+		dir = filepath.Join(dir, "content")
+	}
+
+	err := siteTreeFiles(dir, func(rel string, data []byte) error {
+		base := path.Base(rel)
+		if strings.HasPrefix(base, "_index.") {
+			// section listings, they're generated here
+			report.Skipped = append(report.Skipped, rel)
+			return nil
+		}
+
+		ext := strings.ToLower(path.Ext(rel))
+		writ, m, err := siteTreeWrit(data, ext)
+		if err != nil {
+			report.Fail(rel, err)
+			return nil
+		}
+
+		name := strings.TrimSuffix(base, path.Ext(base))
+		if name == "index" {
+			// a page bundle, named after its directory
+			name = path.Base(path.Dir(rel))
+		}
+		if len(writ.Slug) == 0 {
+			writ.Slug = name
+		}
+
+		if draft, _ := fmBool(m, "draft"); draft {
+			writ.State = WritDraft
+		} else {
+			writ.State = WritPublished
+		}
+
+		old := fmString(m, "url")
+		if len(old) == 0 {
+			old = "/" + writ.Slug + "/"
+			if section := strings.SplitN(rel, "/", 2); len(section) == 2 && section[0] != name {
+				old = "/" + section[0] + old
+			}
+		}
+		writ.Aliases = appendUnique(fmStrings(m, "aliases"), old)
+
+		importMigrated(rel, writ, author, report)
+		return nil
+	})
+	return report, err
+}
+
+// ImportJekyll import the posts and drafts of a jekyll site, dir can be the site or its _posts directory
+func ImportJekyll(dir, author string) (*ImportReport, error) {
+	report := MakeImportReport()
+
+	// posts before drafts, and the files in each in order, so slug clashes
+	// and the report come out the same every time
+	type postDir struct {
+		dir    string
+		drafts bool
+	}
+	dirs := []postDir{}
+	if info, err := os.Stat(filepath.Join(dir, "_posts")); err == nil && info.IsDir() {
+		dirs = append(dirs, postDir{filepath.Join(dir, "_posts"), false})
+		if info, err := os.Stat(filepath.Join(dir, "_drafts")); err == nil && info.IsDir() {
+			dirs = append(dirs, postDir{filepath.Join(dir, "_drafts"), true})
+		}
+	} else {
+		dirs = append(dirs, postDir{dir, false})
+	}
+
+	for _, postdir := range dirs {
+		drafts := postdir.drafts
+		err := siteTreeFiles(postdir.dir, func(rel string, data []byte) error {
+			base := path.Base(rel)
+			ext := strings.ToLower(path.Ext(base))
+			name := strings.TrimSuffix(base, path.Ext(base))
+
+			parts := jekyllPostName.FindStringSubmatch(name)
+			if parts == nil && !drafts {
+				report.Skipped = append(report.Skipped, rel)
+				return nil
+			}
+
+			writ, m, err := siteTreeWrit(data, ext)
+			if err != nil {
+				report.Fail(rel, err)
+				return nil
+			}
+
+			if parts != nil {
+				name = parts[4]
+				if writ.Created.IsZero() {
+					writ.Created, _ = time.Parse("2006-01-02", parts[1]+"-"+parts[2]+"-"+parts[3])
+				}
+			}
+			if len(writ.Slug) == 0 {
+				writ.Slug = name
+			}
+
+			if published, ok := fmBool(m, "published"); drafts || (ok && !published) {
+				writ.State = WritDraft
+			} else {
+				writ.State = WritPublished
+			}
+
+			old := fmString(m, "permalink")
+			if len(old) == 0 && !writ.Created.IsZero() {
+				categories := []string{}
+				for _, category := range fmStrings(m, "categories", "category") {
+					categories = append(categories, strings.ToLower(category))
+				}
+				old = "/" + path.Join(append(categories, writ.Created.Format("2006/01/02"), name+".html")...)
+			}
+			writ.Aliases = fmStrings(m, "redirect_from")
+			if len(old) != 0 {
+				writ.Aliases = appendUnique(writ.Aliases, old)
+			}
+
+			importMigrated(rel, writ, author, report)
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// importMigrated store a writ brought over from another platform,
+// falling back on author when the original one isn't a user here
+func importMigrated(name string, writ *Writ, author string, report *ImportReport) {
+	if len(writ.Tags) == 0 {
+		writ.Tags = []string{DefaultImportTag}
+	}
+	if _, err := UserByUsername(writ.Author); err != nil && len(author) != 0 {
+		writ.Author = author
+	}
+
+	created, err := importWrit(writ, author, nil)
+	if err != nil {
+		report.Fail(name, err)
+	} else if created {
+		report.Created = append(report.Created, name)
+	} else {
+		report.Updated = append(report.Updated, name)
+	}
+}
+
+// platformImportCommand a subcommand that imports from another blogging platform's files
+func platformImportCommand(name, description string, run func(source, author string) (*ImportReport, error)) *Command {
+	var source, author string
+	sc := flaggy.NewSubcommand(name)
+	sc.Description = description
+	sc.AddPositionalValue(&source, "source", 1, true, "the export file or site directory to import from")
+	sc.String(&author, "a", "author", "who writs are attributed to when their original author isn't a user here")
+
+	return &Command{
+		Subcommand: sc,
+		Run: func() error {
+			report, err := run(source, author)
+			printImportReport(report)
+			return err
+		},
+	}
+}
@@ -99,8 +99,15 @@ func Init() {
 	var donotRatelimit bool
 	flaggy.Bool(&donotRatelimit, "nr", "no-ratelimit", "should the server not ratelimit?")
 
+	setupCommands()
+
 	flaggy.Parse()
 
+	command := usedCommand()
+	if command != nil && command.Prepare != nil {
+		command.Prepare()
+	}
+
 	Conf = digestConfig(confloc)
 
 	Conf.DevMode = DevMode
@@ -178,25 +185,10 @@ func Init() {
 	fmt.Println("Firing up: ", AppName+"...")
 	fmt.Println("\nDevMode: ", DevMode)
 
-	dbobj := Conf.Raw["db"].(obj)
-
-	addrs := interfaceSliceToStringSlice(dbobj["local_address"].([]interface{}))
-
-	dbname := dbobj["name"].(string)
-	dbusername := dbobj["username"].(string)
-	dbpassword := dbobj["password"].(string)
-
-	err = setupDB(addrs, dbname, dbusername, dbpassword)
+	err = setupStorage()
 	if err != nil {
-		fmt.Println("couldn't connect to DB locally, trying remote connection now...")
-
-		addrs = interfaceSliceToStringSlice(dbobj["address"].([]interface{}))
-
-		err = setupDB(addrs, dbname, dbusername, dbpassword)
-		if err != nil {
-			fmt.Println(aurora.Brown("couldn't get DB connection going: "), aurora.Red(err))
-			panic(err)
-		}
+		fmt.Println(aurora.Brown("couldn't get storage going: "), aurora.Red(err))
+		panic(err)
 	}
 
 	EmailConf.Email = mailerObj["email"].(string)
@@ -219,22 +211,47 @@ func Init() {
 
 	startEmailer()
 
+	if runCommand() {
+		return
+	}
+
 	startDBHealthCheck()
 	defer DBHealthTicker.Stop()
 
 	startSelfManaging()
+	startPublishScheduler()
+	startBackupScheduler()
 
 	startTemplating()
 
 	initAuth()
 	initWrits()
+	initComments()
+	initRevisions()
+	initFeeds()
+	initSitemap()
+	initSearch()
+	initImport()
+	initBackup()
+	initSeries()
+	initRelated()
+	initMedia()
+	initOGImages()
 
 	Server.HTTPErrorHandler = func(err error, c ctx) {
 		if err == Err404NotFound {
+			if redirectOldPath(c) {
+				return
+			}
 			Err404NotFound.Send(c)
 			return
 		}
 
+		if err == Err403Forbidden {
+			Err403Forbidden.Send(c)
+			return
+		}
+
 		cmsg, ok := err.(*CodedResponse)
 		if ok {
 			cmsg.SendJSON(c)
@@ -259,7 +276,7 @@ func Init() {
 					_, ok := err.(*CodedResponse)
 					if !ok {
 						ext := filepath.Ext(path)
-						if ext == ".png" || ext == ".webp" {
+						if ext == ".png" || ext == ".webp" || strings.HasPrefix(path, "/"+Conf.Media+"/") {
 							res.Header().Set("Cache-Control", "public, max-age=30672000")
 						}
 						err = Cache.Serve(res.Writer, req)
@@ -373,6 +390,7 @@ func Init() {
 		Cache = cache
 	
 		Err404NotFound = MakePageErr(404, "Not Found", "/404.html")
+		Err403Forbidden = MakePageErr(403, "Forbidden", "/403.html")
 
 		Cache.NotFoundError = Err404NotFound
 		Cache.NotFoundHandler = nil
@@ -498,10 +516,22 @@ type Config struct {
 	Assets           string `json:"assets,omitempty" toml:"assets,omitempty"`
 	DoNotWatchAssets bool   `json:"do_not_watch_assets,omitempty" toml:"do_not_watch_assets,omitempty"`
 
+	// Media where uploads go, a directory inside Assets so they're served from /<media>/
+	Media string `json:"media,omitempty" toml:"media,omitempty"`
+
 	Private string `json:"private,omitempty" toml:"private,omitempty"`
 
 	Cache string `json:"cache,omitempty" toml:"cache,omitempty"`
 
+	Storage     string `json:"storage,omitempty" toml:"storage,omitempty"`
+	StorageFile string `json:"storage_file,omitempty" toml:"storage_file,omitempty"`
+
+	Backups BackupConfig `json:"backups,omitempty" toml:"backups,omitempty"`
+
+	Markdown MarkdownConfig `json:"markdown,omitempty" toml:"markdown,omitempty"`
+
+	ContentPolicy ContentPolicyConfig `json:"content_policy,omitempty" toml:"content_policy,omitempty"`
+
 	Raw map[string]interface{} `json:"-" toml:"-"`
 }
 
@@ -541,6 +571,10 @@ func digestConfig(location string) *Config {
 		conf.Assets = "./assets"
 	}
 
+	if conf.Media == "" {
+		conf.Media = "media"
+	}
+
 	if conf.Certs == "" {
 		conf.Certs = conf.Private + "/certs"
 	}
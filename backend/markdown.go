@@ -0,0 +1,368 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/russross/blackfriday/v2"
+)
+
+// MarkdownConfig how writs' markdown is rendered
+type MarkdownConfig struct {
+	// Disable extensions that are otherwise on: footnotes, tables, anchors, toc,
+	// tasklists, highlight, shortcodes and responsive-images, or any added with AddMarkdownHook
+	Disable []string `json:"disable,omitempty" toml:"disable,omitempty"`
+	// TOCDepth the deepest heading level that makes it into the table of contents, 3 by default
+	TOCDepth int `json:"toc_depth,omitempty" toml:"toc_depth,omitempty"`
+	// TOCMin how many headings a writ needs to get a table of contents, 3 by default
+	TOCMin int `json:"toc_min,omitempty" toml:"toc_min,omitempty"`
+	// WordsPerMinute how fast readers are reckoned to read when working out reading times
+	WordsPerMinute int `json:"words_per_minute,omitempty" toml:"words_per_minute,omitempty"`
+	// ExcerptLength roughly how many characters the excerpts taken from writs' first paragraphs have
+	ExcerptLength int `json:"excerpt_length,omitempty" toml:"excerpt_length,omitempty"`
+}
+
+// TOCEntry a heading in a writ's table of contents
+type TOCEntry struct {
+	Level int    `json:"level" msgpack:"level"`
+	ID    string `json:"id" msgpack:"id"`
+	Title string `json:"title" msgpack:"title"`
+}
+
+// MarkdownStage when in rendering a markdown hook runs
+type MarkdownStage int
+
+const (
+	// BeforeParse hooks get the markdown source
+	BeforeParse MarkdownStage = iota
+	// AfterParse hooks get the syntax tree blackfriday parsed
+	AfterParse
+	// AfterRender hooks get the finished html
+	AfterRender
+)
+
+// MarkdownDoc markdown on its way through the pipeline
+type MarkdownDoc struct {
+	// Writ the writ being rendered
+	Writ   *Writ
+	Source []byte
+	Tree   *blackfriday.Node
+	HTML   []byte
+	TOC    []TOCEntry
+
+	// embeds the html shortcodes made, waiting on the markdown to be rendered
+	embeds     []string
+	embedNonce string
+}
+
+// MarkdownHook a step in rendering markdown, it can be turned off by its name in the config
+type MarkdownHook struct {
+	Name  string
+	Stage MarkdownStage
+	Run   func(doc *MarkdownDoc) error
+}
+
+// NodeRenderer renders one type of node itself instead of leaving it to blackfriday,
+// Render reports false when it'd rather blackfriday did after all
+type NodeRenderer struct {
+	Name   string
+	Type   blackfriday.NodeType
+	Render func(doc *MarkdownDoc, w io.Writer, node *blackfriday.Node, entering bool) (blackfriday.WalkStatus, bool)
+}
+
+// markdownHooks the steps writs go through, in order within each stage
+var markdownHooks = []MarkdownHook{
+	{Name: "heading-ids", Stage: AfterParse, Run: uniqueHeadingIDs},
+	{Name: "toc", Stage: AfterParse, Run: tableOfContents},
+	{Name: "anchors", Stage: AfterParse, Run: headingAnchors},
+	{Name: "tasklists", Stage: AfterParse, Run: taskLists},
+	{Name: "responsive-images", Stage: AfterRender, Run: func(doc *MarkdownDoc) error {
+		doc.HTML = responsiveImages(doc.HTML)
+		return nil
+	}},
+}
+
+var nodeRenderers = []NodeRenderer{
+	{Name: "highlight", Type: blackfriday.CodeBlock, Render: highlightCodeBlock},
+}
+
+// AddMarkdownHook add a step to rendering writs, after the ones already in its stage
+func AddMarkdownHook(hook MarkdownHook) {
+	markdownHooks = append(markdownHooks, hook)
+}
+
+// AddNodeRenderer take over rendering a type of node, ahead of any renderers already added
+func AddNodeRenderer(renderer NodeRenderer) {
+	nodeRenderers = append([]NodeRenderer{renderer}, nodeRenderers...)
+}
+
+// markdownEnabled whether an extension or hook hasn't been turned off in the config
+func markdownEnabled(name string) bool {
+	return Conf == nil || !stringsContain(Conf.Markdown.Disable, name)
+}
+
+// pipelineRenderer blackfriday's html renderer, save for the nodes a NodeRenderer takes
+type pipelineRenderer struct {
+	*blackfriday.HTMLRenderer
+	doc       *MarkdownDoc
+	renderers []NodeRenderer
+}
+
+func (r *pipelineRenderer) RenderNode(w io.Writer, node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	for _, nr := range r.renderers {
+		if nr.Type == node.Type {
+			if status, ok := nr.Render(r.doc, w, node, entering); ok {
+				return status
+			}
+		}
+	}
+	return r.HTMLRenderer.RenderNode(w, node, entering)
+}
+
+// runMarkdownHooks run the enabled hooks of a stage, one failing doesn't stop the rest
+func runMarkdownHooks(stage MarkdownStage, doc *MarkdownDoc) {
+	for _, hook := range markdownHooks {
+		if hook.Stage != stage || !markdownEnabled(hook.Name) {
+			continue
+		}
+		if err := hook.Run(doc); err != nil && DevMode {
+			fmt.Println("markdown: the ", hook.Name, " hook failed - ", err)
+		}
+	}
+}
+
+// RenderWritMarkdown put a writ's markdown through the pipeline
+func RenderWritMarkdown(w *Writ) *MarkdownDoc {
+	doc := &MarkdownDoc{Writ: w, Source: []byte(w.Markdown)}
+	runMarkdownHooks(BeforeParse, doc)
+
+	extensions := blackfriday.CommonExtensions
+	if markdownEnabled("footnotes") {
+		extensions |= blackfriday.Footnotes
+	}
+	if !markdownEnabled("tables") {
+		extensions &^= blackfriday.Tables
+	}
+	if markdownEnabled("anchors") || markdownEnabled("toc") {
+		extensions |= blackfriday.AutoHeadingIDs
+	}
+
+	flags := blackfriday.CommonHTMLFlags
+	if markdownEnabled("footnotes") {
+		flags |= blackfriday.FootnoteReturnLinks
+	}
+	renderer := &pipelineRenderer{
+		HTMLRenderer: blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{Flags: flags}),
+		doc:          doc,
+	}
+	for _, nr := range nodeRenderers {
+		if markdownEnabled(nr.Name) {
+			renderer.renderers = append(renderer.renderers, nr)
+		}
+	}
+
+	parser := blackfriday.New(blackfriday.WithRenderer(renderer), blackfriday.WithExtensions(extensions))
+	doc.Tree = parser.Parse(doc.Source)
+	runMarkdownHooks(AfterParse, doc)
+
+	var buf bytes.Buffer
+	renderer.RenderHeader(&buf, doc.Tree)
+	doc.Tree.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		return renderer.RenderNode(&buf, node, entering)
+	})
+	renderer.RenderFooter(&buf, doc.Tree)
+	doc.HTML = buf.Bytes()
+
+	runMarkdownHooks(AfterRender, doc)
+	return doc
+}
+
+// eachHeading call fn with every heading in the tree
+func eachHeading(tree *blackfriday.Node, fn func(heading *blackfriday.Node)) {
+	tree.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && node.Type == blackfriday.Heading && !node.IsTitleblock {
+			fn(node)
+			return blackfriday.SkipChildren
+		}
+		return blackfriday.GoToNext
+	})
+}
+
+// nodeText the plain text inside a node
+func nodeText(node *blackfriday.Node) string {
+	var text strings.Builder
+	node.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && (n.Type == blackfriday.Text || n.Type == blackfriday.Code) {
+			text.Write(n.Literal)
+		}
+		return blackfriday.GoToNext
+	})
+	return strings.TrimSpace(text.String())
+}
+
+// uniqueHeadingIDs make sure every heading has an id and no two share one,
+// so the table of contents and the anchors agree with what's rendered
+func uniqueHeadingIDs(doc *MarkdownDoc) error {
+	seen := map[string]bool{}
+	n := 0
+	eachHeading(doc.Tree, func(heading *blackfriday.Node) {
+		n++
+		id := heading.HeadingID
+		if len(id) == 0 {
+			id = "section-" + strconv.Itoa(n)
+		}
+		base := id
+		for i := 1; seen[id]; i++ {
+			id = base + "-" + strconv.Itoa(i)
+		}
+		seen[id] = true
+		heading.HeadingID = id
+	})
+	return nil
+}
+
+// tableOfContents list the headings, when there are enough of them
+func tableOfContents(doc *MarkdownDoc) error {
+	depth, min := 0, 0
+	if Conf != nil {
+		depth, min = Conf.Markdown.TOCDepth, Conf.Markdown.TOCMin
+	}
+	if depth == 0 {
+		depth = 3
+	}
+	if min == 0 {
+		min = 3
+	}
+
+	toc := []TOCEntry{}
+	eachHeading(doc.Tree, func(heading *blackfriday.Node) {
+		if heading.Level <= depth {
+			toc = append(toc, TOCEntry{Level: heading.Level, ID: heading.HeadingID, Title: nodeText(heading)})
+		}
+	})
+	if len(toc) >= min {
+		doc.TOC = toc
+	}
+	return nil
+}
+
+// headingAnchors give every heading a link to itself
+func headingAnchors(doc *MarkdownDoc) error {
+	eachHeading(doc.Tree, func(heading *blackfriday.Node) {
+		anchor := blackfriday.NewNode(blackfriday.HTMLSpan)
+		anchor.Literal = []byte(`<a class="anchor" href="#` + html.EscapeString(heading.HeadingID) + `" aria-hidden="true">#</a>`)
+		heading.AppendChild(anchor)
+	})
+	return nil
+}
+
+var taskMarkers = map[string]string{
+	"[ ] ": `<input type="checkbox" class="task" disabled> `,
+	"[x] ": `<input type="checkbox" class="task" disabled checked> `,
+	"[X] ": `<input type="checkbox" class="task" disabled checked> `,
+}
+
+// taskLists turn list items starting with [ ] or [x] into checkboxes
+func taskLists(doc *MarkdownDoc) error {
+	doc.Tree.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || node.Type != blackfriday.Item {
+			return blackfriday.GoToNext
+		}
+		para := node.FirstChild
+		if para == nil || para.Type != blackfriday.Paragraph || para.FirstChild == nil {
+			return blackfriday.GoToNext
+		}
+		text := para.FirstChild
+		if text.Type != blackfriday.Text || len(text.Literal) < 4 {
+			return blackfriday.GoToNext
+		}
+		if checkbox, ok := taskMarkers[string(text.Literal[:4])]; ok {
+			box := blackfriday.NewNode(blackfriday.HTMLSpan)
+			box.Literal = []byte(checkbox)
+			text.Literal = text.Literal[4:]
+			text.InsertBefore(box)
+		}
+		return blackfriday.GoToNext
+	})
+	return nil
+}
+
+// prismClass the prism token class for a chroma token type, so prism's themes style
+// server highlighted code the same as they would in the browser
+func prismClass(t chroma.TokenType) string {
+	switch {
+	case t == chroma.KeywordConstant:
+		return "boolean"
+	case t.InCategory(chroma.Keyword), t.InSubCategory(chroma.CommentPreproc):
+		return "keyword"
+	case t.InCategory(chroma.Comment):
+		return "comment"
+	case t == chroma.NameFunction, t == chroma.NameFunctionMagic, t == chroma.NameDecorator:
+		return "function"
+	case t == chroma.NameClass, t == chroma.NameException:
+		return "class-name"
+	case t == chroma.NameBuiltin, t == chroma.NameBuiltinPseudo:
+		return "builtin"
+	case t == chroma.NameTag:
+		return "tag"
+	case t == chroma.NameAttribute:
+		return "attr-name"
+	case t == chroma.NameConstant:
+		return "constant"
+	case t == chroma.NameProperty:
+		return "property"
+	case t == chroma.LiteralStringRegex:
+		return "regex"
+	case t.InSubCategory(chroma.LiteralString):
+		return "string"
+	case t.InSubCategory(chroma.LiteralNumber):
+		return "number"
+	case t.InCategory(chroma.Operator):
+		return "operator"
+	case t.InCategory(chroma.Punctuation):
+		return "punctuation"
+	case t == chroma.GenericDeleted:
+		return "deleted"
+	case t == chroma.GenericInserted:
+		return "inserted"
+	}
+	return ""
+}
+
+// highlightCodeBlock highlight fenced code in a language chroma knows,
+// anything else is left to blackfriday
+func highlightCodeBlock(doc *MarkdownDoc, w io.Writer, node *blackfriday.Node, entering bool) (blackfriday.WalkStatus, bool) {
+	lang := strings.Fields(string(node.Info))
+	if len(lang) == 0 {
+		return blackfriday.GoToNext, false
+	}
+	lexer := lexers.Get(lang[0])
+	if lexer == nil {
+		return blackfriday.GoToNext, false
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, string(node.Literal))
+	if err != nil {
+		return blackfriday.GoToNext, false
+	}
+
+	class := html.EscapeString("language-" + lang[0])
+	var out bytes.Buffer
+	out.WriteString(`<pre class="` + class + `"><code class="` + class + `">`)
+	for token := tokens(); token != chroma.EOF; token = tokens() {
+		value := html.EscapeString(token.Value)
+		if c := prismClass(token.Type); len(c) != 0 {
+			out.WriteString(`<span class="token ` + c + `">` + value + `</span>`)
+		} else {
+			out.WriteString(value)
+		}
+	}
+	out.WriteString("</code></pre>\n")
+	w.Write(out.Bytes())
+	return blackfriday.GoToNext, true
+}
@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+)
+
+func renderTestMarkdown(markdown string) *MarkdownDoc {
+	return RenderWritMarkdown(&Writ{Key: "test", Markdown: markdown})
+}
+
+func TestUniqueHeadingIDs(t *testing.T) {
+	Conf = &Config{}
+	doc := renderTestMarkdown("# Setup\n\ntext\n\n## Setup\n\ntext\n\n### Setup\n")
+
+	want := []string{"setup", "setup-1", "setup-2"}
+	if len(doc.TOC) != len(want) {
+		t.Fatalf("got %d entries in the toc, want %d: %v", len(doc.TOC), len(want), doc.TOC)
+	}
+	for i, entry := range doc.TOC {
+		if entry.ID != want[i] {
+			t.Errorf("heading %d has the id %q, want %q", i, entry.ID, want[i])
+		}
+		if !strings.Contains(string(doc.HTML), `id="`+want[i]+`"`) {
+			t.Errorf("the html doesn't have a heading with the id %q", want[i])
+		}
+	}
+}
+
+func TestTOCMin(t *testing.T) {
+	cases := []struct {
+		min      int
+		headings int
+		want     int
+	}{
+		{0, 2, 0},
+		{0, 3, 3},
+		{2, 2, 2},
+		{5, 4, 0},
+	}
+	for _, tc := range cases {
+		Conf = &Config{Markdown: MarkdownConfig{TOCMin: tc.min}}
+		markdown := strings.Repeat("## Heading\n\nsome text\n\n", tc.headings)
+		if got := len(renderTestMarkdown(markdown).TOC); got != tc.want {
+			t.Errorf("toc_min %d with %d headings: got %d toc entries, want %d", tc.min, tc.headings, got, tc.want)
+		}
+	}
+}
+
+func TestTaskLists(t *testing.T) {
+	Conf = &Config{}
+	out := string(renderTestMarkdown("- [ ] todo\n- [x] done\n- [X] also done\n- plain [ ] item\n").HTML)
+
+	if n := strings.Count(out, `<input type="checkbox" class="task" disabled> todo`); n != 1 {
+		t.Errorf("want one unchecked box, got %d in %s", n, out)
+	}
+	if n := strings.Count(out, `<input type="checkbox" class="task" disabled checked>`); n != 2 {
+		t.Errorf("want two checked boxes, got %d in %s", n, out)
+	}
+	if !strings.Contains(out, "<li>plain [ ] item</li>") {
+		t.Errorf("a marker that doesn't start the item was turned into a box: %s", out)
+	}
+}
+
+func TestMarkdownDisable(t *testing.T) {
+	Conf = &Config{Markdown: MarkdownConfig{Disable: []string{"tasklists", "toc"}}}
+	doc := renderTestMarkdown("# a\n\n# b\n\n# c\n\n- [ ] todo\n")
+	if len(doc.TOC) != 0 {
+		t.Errorf("the toc is disabled but there's one: %v", doc.TOC)
+	}
+	if strings.Contains(string(doc.HTML), "checkbox") {
+		t.Errorf("task lists are disabled but there's a checkbox: %s", doc.HTML)
+	}
+}
@@ -0,0 +1,468 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxImagePixels images bigger than this are kept as they are, decoding them would eat too much memory
+const MaxImagePixels = 50000000
+
+// MediaSizes the sizes attribute for responsive images in writs, they're at most as wide as the writ column
+const MediaSizes = "(max-width: 768px) 100vw, 768px"
+
+// ErrImageTooBig the image is too large to process
+var ErrImageTooBig = errors.New("the image is too big to process")
+
+// MediaVariant a scaled down or webp copy of an uploaded image
+type MediaVariant struct {
+	Name   string `json:"name" msgpack:"name"`
+	File   string `json:"file" msgpack:"file"`
+	URL    string `json:"url" msgpack:"url"`
+	Mime   string `json:"mime" msgpack:"mime"`
+	Width  int    `json:"width" msgpack:"width"`
+	Height int    `json:"height" msgpack:"height"`
+	Size   int64  `json:"size" msgpack:"size"`
+}
+
+// imageVariants the widths uploaded images are scaled down to,
+// an image only gets the ones narrower than itself
+var imageVariants = []struct {
+	Name  string
+	Width int
+}{
+	{"thumbnail", 320},
+	{"medium", 768},
+	{"large", 1536},
+}
+
+// largestVariant the width of the biggest variant an image can get
+func largestVariant() int {
+	return imageVariants[len(imageVariants)-1].Width
+}
+
+// jpegSegmentsToDrop the jpeg segments that carry metadata rather than the picture:
+// APP1 (exif and xmp), APP13 (iptc) and comments
+var jpegSegmentsToDrop = map[byte]bool{0xe1: true, 0xed: true, 0xfe: true}
+
+// pngChunksToDrop the png chunks that carry metadata rather than the picture
+var pngChunksToDrop = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// webpChunksToDrop the webp chunks that carry metadata rather than the picture
+var webpChunksToDrop = map[string]bool{"EXIF": true, "XMP ": true}
+
+// exifOrientation the orientation tag from an exif segment, 1 (upright) when there isn't one
+func exifOrientation(exif []byte) int {
+	if !bytes.HasPrefix(exif, []byte("Exif\x00\x00")) || len(exif) < 14 {
+		return 1
+	}
+	tiff := exif[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// stripJPEGMetadata drop the metadata segments from a jpeg, without touching the picture itself,
+// along with the orientation the exif gave it
+func stripJPEGMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return data, 1, errors.New("not a jpeg")
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	orientation := 1
+
+	i := 2
+	for i+1 < len(data) {
+		if data[i] != 0xff {
+			return data, 1, errors.New("malformed jpeg")
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			// the compressed picture follows, it's copied as is
+			return append(out, data[i:]...), orientation, nil
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			break
+		}
+		if marker == 0xe1 && orientation == 1 {
+			orientation = exifOrientation(data[i+4 : end])
+		}
+		if !jpegSegmentsToDrop[marker] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return data, 1, errors.New("truncated jpeg")
+}
+
+// stripPNGMetadata drop the text, time and exif chunks from a png
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 8 || string(data[1:4]) != "PNG" {
+		return data, errors.New("not a png")
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:8]...)
+	for i := 8; i < len(data); {
+		if i+12 > len(data) {
+			return data, errors.New("truncated png")
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return data, errors.New("truncated png")
+		}
+		if !pngChunksToDrop[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// stripWebPMetadata drop the exif and xmp chunks from a webp, and the flags saying it has them
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return data, errors.New("not a webp")
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return data, errors.New("truncated webp")
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		// chunks are padded to an even length
+		end := i + 8 + size + size&1
+		if end > len(data) || end < i {
+			return data, errors.New("truncated webp")
+		}
+		fourcc := string(data[i : i+4])
+		if !webpChunksToDrop[fourcc] {
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if fourcc == "VP8X" && size >= 1 {
+				// clear the exif and xmp flags
+				out[start+8] &^= 0x0c
+			}
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// stripMetadata take the metadata (camera, location and the like) out of an upload
+// where the format allows, reporting the exif orientation of jpegs; when it can't
+// be done the upload shouldn't be kept, it could give away where someone lives
+func stripMetadata(mime string, data []byte) ([]byte, int, error) {
+	var err error
+	orientation := 1
+	switch mime {
+	case "image/jpeg":
+		data, orientation, err = stripJPEGMetadata(data)
+	case "image/png":
+		data, err = stripPNGMetadata(data)
+	case "image/webp":
+		data, err = stripWebPMetadata(data)
+	}
+	if err != nil && DevMode {
+		fmt.Println("couldn't strip the metadata from an upload - ", err)
+	}
+	return data, orientation, err
+}
+
+// toRGBA a copy of an image as premultiplied rgba, starting at 0,0
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// orient turn and flip an image the way its exif orientation says it should be shown
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):])
+		}
+	}
+	return dst
+}
+
+type areaWeight struct {
+	index  int
+	weight float64
+}
+
+// areaWeights which source pixels each destination pixel covers, and how much of it they make up
+func areaWeights(src, dst int) [][]areaWeight {
+	scale := float64(src) / float64(dst)
+	weights := make([][]areaWeight, dst)
+	for d := range weights {
+		start, end := float64(d)*scale, float64(d+1)*scale
+		for s := int(start); float64(s) < end && s < src; s++ {
+			lo := math.Max(start, float64(s))
+			hi := math.Min(end, float64(s+1))
+			weights[d] = append(weights[d], areaWeight{s, (hi - lo) / scale})
+		}
+	}
+	return weights
+}
+
+// resizeImage scale an image down by averaging the pixels each new pixel covers
+func resizeImage(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	xWeights, yWeights := areaWeights(sw, width), areaWeights(sh, height)
+
+	// across first, then down
+	across := make([]float64, width*sh*4)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, weights := range xWeights {
+			out := across[(y*width+x)*4:]
+			for _, w := range weights {
+				for c := 0; c < 4; c++ {
+					out[c] += float64(row[w.index*4+c]) * w.weight
+				}
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, weights := range yWeights {
+		for x := 0; x < width; x++ {
+			var sum [4]float64
+			for _, w := range weights {
+				in := across[(w.index*width+x)*4:]
+				for c := 0; c < 4; c++ {
+					sum[c] += in[c] * w.weight
+				}
+			}
+			px := dst.Pix[dst.PixOffset(x, y):]
+			for c := 0; c < 4; c++ {
+				px[c] = uint8(math.Min(255, math.Max(0, math.Round(sum[c]))))
+			}
+		}
+	}
+	return dst
+}
+
+// encodeImage an image in the given format
+func encodeImage(img image.Image, mime string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch mime {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 82})
+	case "image/webp":
+		err = encodeWebP(&buf, img)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// addVariant write out a variant of an upload and list it
+func addVariant(m *MediaFile, name, mime string, data []byte, width, height int) error {
+	v := MediaVariant{
+		Name:   name,
+		File:   m.Key + "-" + name + mediaTypes[mime],
+		Mime:   mime,
+		Width:  width,
+		Height: height,
+		Size:   int64(len(data)),
+	}
+	v.URL = mediaURL(v.File)
+	if err := writeMediaFile(v.File, data); err != nil {
+		return err
+	}
+	m.Variants = append(m.Variants, v)
+	return nil
+}
+
+// processImage strip an uploaded image of its metadata, turn it upright,
+// and make the scaled down and webp variants for it; it returns the data to keep as the original
+func processImage(m *MediaFile, data []byte, orientation int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// not something the standard library can read, like webp, so it's kept as it is
+		return data, nil
+	}
+	m.Width, m.Height = config.Width, config.Height
+	if m.Mime != "image/jpeg" && m.Mime != "image/png" {
+		// gifs would lose their animation
+		return data, nil
+	}
+	if config.Width*config.Height > MaxImagePixels {
+		return data, nil
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return data, err
+	}
+	img := toRGBA(decoded)
+	if orientation > 1 {
+		img = orient(img, orientation)
+		if data, err = encodeImage(img, m.Mime); err != nil {
+			return data, err
+		}
+	}
+	m.Width, m.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	m.Variants = []MediaVariant{}
+	for _, size := range imageVariants {
+		if size.Width >= m.Width {
+			break
+		}
+		height := int(math.Max(1, math.Round(float64(m.Height*size.Width)/float64(m.Width))))
+		scaled := resizeImage(img, size.Width, height)
+
+		fallback, err := encodeImage(scaled, m.Mime)
+		if err != nil {
+			return data, err
+		}
+		if len(fallback) >= len(data) {
+			// smaller on screen but not on the wire, the original does just as well
+			continue
+		}
+		if err = addVariant(m, size.Name, m.Mime, fallback, size.Width, height); err != nil {
+			return data, err
+		}
+		// the webp is only worth having when it's the smaller of the two
+		if webp, err := encodeImage(scaled, "image/webp"); err == nil && len(webp) < len(fallback) {
+			if err = addVariant(m, size.Name, "image/webp", webp, size.Width, height); err != nil {
+				return data, err
+			}
+		}
+	}
+	if m.Width > largestVariant() {
+		// the large variant does for any screen, the original isn't offered in the srcset
+		return data, nil
+	}
+	if webp, err := encodeImage(img, "image/webp"); err == nil && len(webp) < len(data) {
+		if err = addVariant(m, "full", "image/webp", webp, m.Width, m.Height); err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// responsiveImage the markup for an uploaded image: a picture with webp sources when
+// every size has one, and a srcset so small screens get small files
+func responsiveImage(m *MediaFile, attrs string) string {
+	fallback := []string{}
+	webp := []string{}
+	for _, v := range m.Variants {
+		entry := v.URL + " " + strconv.Itoa(v.Width) + "w"
+		if v.Mime == "image/webp" {
+			webp = append(webp, entry)
+		} else {
+			fallback = append(fallback, entry)
+		}
+	}
+	if m.Width <= largestVariant() {
+		fallback = append(fallback, m.URL+" "+strconv.Itoa(m.Width)+"w")
+	}
+
+	img := `<img src="` + m.URL + `" srcset="` + strings.Join(fallback, ", ") + `" sizes="` + MediaSizes +
+		`" width="` + strconv.Itoa(m.Width) + `" height="` + strconv.Itoa(m.Height) + `" loading="lazy"` + attrs + ` />`
+	if len(webp) != len(fallback) {
+		return img
+	}
+	return `<picture><source type="image/webp" srcset="` + strings.Join(webp, ", ") + `" sizes="` + MediaSizes + `">` + img + `</picture>`
+}
+
+// responsiveImages give the uploaded images in rendered markdown their variants
+func responsiveImages(content []byte) []byte {
+	compileMediaPatterns()
+	pattern := mediaPatterns.images
+	return pattern.ReplaceAllFunc(content, func(tag []byte) []byte {
+		match := pattern.FindSubmatch(tag)
+		m, err := Stores.Media.ByKey(string(match[1]))
+		if err != nil || len(m.Variants) == 0 || m.Width == 0 {
+			return tag
+		}
+		// blackfriday has escaped the alt and title already, they're carried over as they are
+		attrs := string(match[2])
+		if strings.Contains(attrs, "srcset=") {
+			return tag
+		}
+		return []byte(responsiveImage(&m, attrs))
+	})
+}
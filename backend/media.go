@@ -0,0 +1,308 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	// so images can be read in the formats uploads come in
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxMediaSize the biggest file that can be uploaded
+const MaxMediaSize = 32 << 20
+
+// mediaTypes the kinds of files that can be uploaded and the extension each gets,
+// svg and html are left out since they can carry scripts
+var mediaTypes = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"audio/mpeg":      ".mp3",
+	"audio/wave":      ".wav",
+	"application/ogg": ".ogg",
+	"application/pdf": ".pdf",
+}
+
+// MediaFile an uploaded file, it's named after the hash of its contents
+// so the same file uploaded twice is only kept once
+type MediaFile struct {
+	Key      string    `json:"_key" msgpack:"_key"`
+	Name     string    `json:"name" msgpack:"name"`
+	File     string    `json:"file" msgpack:"file"`
+	URL      string    `json:"url" msgpack:"url"`
+	Mime     string    `json:"mime" msgpack:"mime"`
+	Size     int64     `json:"size" msgpack:"size"`
+	Width    int       `json:"width,omitempty" msgpack:"width,omitempty"`
+	Height   int       `json:"height,omitempty" msgpack:"height,omitempty"`
+	Uploader string    `json:"uploader" msgpack:"uploader"`
+	Uploaded time.Time `json:"uploaded" msgpack:"uploaded"`
+	// Variants the scaled down and webp copies of an image
+	Variants []MediaVariant `json:"variants,omitempty" msgpack:"variants,omitempty"`
+	// Writs the keys of the writs that link to the file
+	Writs []string `json:"writs" msgpack:"writs"`
+}
+
+// mediaDir where uploads are written, it's inside the assets so the Cache serves them
+func mediaDir() string {
+	return filepath.Join(Conf.Assets, filepath.FromSlash(Conf.Media))
+}
+
+// mediaURL where the Cache serves an upload from
+func mediaURL(file string) string {
+	return "/" + strings.Trim(Conf.Media, "/") + "/" + file
+}
+
+// writeMediaFile write an upload, or a variant of one, to the media dir
+func writeMediaFile(file string, data []byte) error {
+	dir := mediaDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	location := filepath.Join(dir, file)
+	tmp := location + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, location); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// the patterns uploads are found in markdown and html by, they depend on
+// the media dir in the config so they're made the first time they're needed
+var mediaPatterns struct {
+	once   sync.Once
+	refs   *regexp.Regexp
+	images *regexp.Regexp
+}
+
+func compileMediaPatterns() {
+	mediaPatterns.once.Do(func() {
+		media := regexp.QuoteMeta(strings.Trim(Conf.Media, "/"))
+		mediaPatterns.refs = regexp.MustCompile(`/` + media + `/([0-9a-f]{64})`)
+		mediaPatterns.images = regexp.MustCompile(`<img src="/` + media + `/([0-9a-f]{64})\.[a-z0-9]+"([^>]*?)\s*/?>`)
+	})
+}
+
+// mediaRefs the keys of the uploads some markdown links to
+func mediaRefs(markdown string) []string {
+	compileMediaPatterns()
+	keys := []string{}
+	for _, match := range mediaPatterns.refs.FindAllStringSubmatch(markdown, -1) {
+		keys = appendUnique(keys, match[1])
+	}
+	return keys
+}
+
+// linkMedia note down which uploads a writ links to now
+func linkMedia(w *Writ) {
+	if len(w.Markdown) == 0 {
+		// the markdown didn't change
+		return
+	}
+	err := Stores.Media.SetWritRefs(w.Key, mediaRefs(w.Markdown))
+	if err != nil && DevMode {
+		fmt.Println("couldn't keep track of the media writ ", w.Key, " uses - ", err)
+	}
+}
+
+// StoreMedia check over an upload, strip its metadata, write it and any variants
+// to the media dir and remember it; a file that's already there is just handed back
+func StoreMedia(name string, data []byte, uploader string) (MediaFile, error) {
+	if len(data) == 0 {
+		return MediaFile{}, MediaEmptyError
+	}
+	if len(data) > MaxMediaSize {
+		return MediaFile{}, MediaTooBigError
+	}
+	mime := http.DetectContentType(data)
+	if i := strings.IndexByte(mime, ';'); i != -1 {
+		mime = mime[:i]
+	}
+	ext, ok := mediaTypes[mime]
+	if !ok {
+		return MediaFile{}, MediaTypeError
+	}
+
+	data, orientation, err := stripMetadata(mime, data)
+	if err != nil {
+		return MediaFile{}, MediaMetadataError
+	}
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	if existing, err := Stores.Media.ByKey(key); err == nil {
+		return existing, nil
+	}
+
+	m := MediaFile{
+		Key:      key,
+		Name:     filepath.Base(name),
+		File:     key + ext,
+		Mime:     mime,
+		Size:     int64(len(data)),
+		Uploader: uploader,
+		Uploaded: time.Now(),
+		Writs:    []string{},
+	}
+	m.URL = mediaURL(m.File)
+
+	if strings.HasPrefix(mime, "image/") {
+		data, err = processImage(&m, data, orientation)
+		if err != nil {
+			removeMediaFiles(&m)
+			return m, err
+		}
+		m.Size = int64(len(data))
+	}
+	if err = writeMediaFile(m.File, data); err != nil {
+		removeMediaFiles(&m)
+		return m, err
+	}
+
+	err = Stores.Media.Create(&m)
+	if err == ErrConflict {
+		// somebody else uploaded it at the same time
+		return Stores.Media.ByKey(key)
+	}
+	return m, err
+}
+
+// RemoveMedia delete an upload no writ uses anymore, its file goes too
+func RemoveMedia(key string) error {
+	m, err := Stores.Media.ByKey(key)
+	if err != nil {
+		if isNotFound(err) {
+			return MediaNotFoundError
+		}
+		return err
+	}
+	if len(m.Writs) != 0 {
+		return MediaInUseError
+	}
+	if err = Stores.Media.Remove(key); err != nil {
+		return err
+	}
+
+	removeMediaFiles(&m)
+	return nil
+}
+
+// removeMediaFiles delete an upload's file and its variants, and drop them from the Cache
+func removeMediaFiles(m *MediaFile) {
+	files := map[string]string{m.File: m.URL}
+	for _, v := range m.Variants {
+		files[v.File] = v.URL
+	}
+	for file, url := range files {
+		err := os.Remove(filepath.Join(mediaDir(), file))
+		if err != nil && !os.IsNotExist(err) && DevMode {
+			fmt.Println("couldn't delete ", file, " from the media dir - ", err)
+		}
+		if Cache != nil {
+			Cache.Del(url)
+		}
+	}
+}
+
+func initMedia() {
+	Server.POST("/media-upload", AdminHandle(func(c ctx, user *User) error {
+		form, err := c.MultipartForm()
+		if err != nil {
+			return BadRequestError.Send(c)
+		}
+
+		files := form.File["files"]
+		if len(files) == 0 {
+			return BadRequestError.Send(c)
+		}
+
+		uploaded := []MediaFile{}
+		failed := []ImportFailure{}
+		for _, fh := range files {
+			if fh.Size > MaxMediaSize {
+				failed = append(failed, ImportFailure{File: fh.Filename, Err: MediaTooBigError.Error()})
+				continue
+			}
+			f, err := fh.Open()
+			if err != nil {
+				failed = append(failed, ImportFailure{File: fh.Filename, Err: err.Error()})
+				continue
+			}
+			data, err := ioutil.ReadAll(f)
+			f.Close()
+			if err == nil {
+				var m MediaFile
+				m, err = StoreMedia(fh.Filename, data, user.Username)
+				if err == nil {
+					uploaded = append(uploaded, m)
+					continue
+				}
+			}
+			if DevMode {
+				fmt.Println("POST /media-upload - couldn't store ", fh.Filename, ": ", err)
+			}
+			failed = append(failed, ImportFailure{File: fh.Filename, Err: err.Error()})
+		}
+
+		return c.Msgpack(200, obj{"uploaded": uploaded, "failed": failed})
+	}))
+
+	Server.GET("/media-library", AdminHandle(func(c ctx, user *User) error {
+		unused := c.QueryParam("unused") == "true" || c.QueryParam("unused") == "1"
+		list, err := Stores.Media.List(strings.ToLower(c.QueryParam("search")), unused)
+		if err != nil && !isNotFound(err) {
+			return ServerDBError.Send(c)
+		}
+		if list == nil {
+			list = []MediaFile{}
+		}
+		return c.Msgpack(200, list)
+	}))
+
+	Server.DELETE("/media-library/:key", AdminHandle(func(c ctx, user *User) error {
+		err := RemoveMedia(c.Param("key"))
+		if err != nil {
+			if cr, ok := err.(*CodedResponse); ok {
+				return cr.Send(c)
+			}
+			return ServerDBError.Send(c)
+		}
+		return c.Msgpack(200, obj{"msg": "upload deleted"})
+	}))
+
+	Server.POST("/media-library/prune", AdminHandle(func(c ctx, user *User) error {
+		unused, err := Stores.Media.List("", true)
+		if err != nil && !isNotFound(err) {
+			return ServerDBError.Send(c)
+		}
+		removed := []string{}
+		for _, m := range unused {
+			if err = RemoveMedia(m.Key); err != nil {
+				if DevMode {
+					fmt.Println("POST /media-library/prune - couldn't delete ", m.Key, ": ", err)
+				}
+				continue
+			}
+			removed = append(removed, m.Key)
+		}
+		return c.Msgpack(200, obj{"removed": removed})
+	}))
+
+	fmt.Println("Media Service Started")
+}
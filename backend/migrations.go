@@ -0,0 +1,344 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/integrii/flaggy"
+)
+
+// MigrationsCollection where applied migrations are recorded
+const MigrationsCollection = "_migrations"
+
+// Migration a versioned change to the shape or the data of the database,
+// once applied it's recorded so it never runs again
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(m *Migrator) error
+}
+
+// MigrationRecord how an applied migration is kept in the _migrations collection
+type MigrationRecord struct {
+	Key     string    `json:"_key,omitempty"`
+	Version int       `json:"version"`
+	Name    string    `json:"name"`
+	Applied time.Time `json:"applied"`
+}
+
+// Migrator is handed to migrations to make their changes through,
+// with DryRun set it only says what it would've done
+type Migrator struct {
+	DryRun bool
+}
+
+// MigrateOnConnect run any pending migrations as soon as the db is connected
+var MigrateOnConnect = true
+
+// Migrations every migration there is, in order, versions must only ever go up
+// and once released a migration shouldn't change, add a new one instead
+var Migrations = []Migration{
+	{1, "users collection and its unique indexes", func(m *Migrator) error {
+		if err := m.Collection("users", &driver.CreateCollectionOptions{WaitForSync: true}); err != nil {
+			return err
+		}
+		err := m.HashIndex("users", []string{"username", "email", "emailmd5"}, &driver.EnsureHashIndexOptions{Unique: true})
+		if err != nil {
+			return err
+		}
+		return m.HashIndex("users", []string{"verifier"}, &driver.EnsureHashIndexOptions{Unique: true, Sparse: true})
+	}},
+	{2, "writs collection and its unique index", func(m *Migrator) error {
+		if err := m.Collection("writs", nil); err != nil {
+			return err
+		}
+		return m.HashIndex("writs", []string{"title", "tags", "slug"}, &driver.EnsureHashIndexOptions{Unique: true})
+	}},
+	{3, "logs and ratelimits collections", func(m *Migrator) error {
+		if err := m.Collection("logs", nil); err != nil {
+			return err
+		}
+		return m.Collection("ratelimits", nil)
+	}},
+	{4, "writ search view", func(m *Migrator) error {
+		return m.View(WritSearchView, ensureSearchView)
+	}},
+	{5, "comments collection and its indexes", func(m *Migrator) error {
+		if err := m.Collection("comments", nil); err != nil {
+			return err
+		}
+		if err := m.HashIndex("comments", []string{"writkey"}, &driver.EnsureHashIndexOptions{}); err != nil {
+			return err
+		}
+		return m.HashIndex("comments", []string{"parent"}, &driver.EnsureHashIndexOptions{Sparse: true})
+	}},
+	{6, "writ_revisions collection and its index", func(m *Migrator) error {
+		if err := m.Collection("writ_revisions", nil); err != nil {
+			return err
+		}
+		return m.SkipListIndex("writ_revisions", []string{"writkey", "replaced"}, &driver.EnsureSkipListIndexOptions{})
+	}},
+	{7, "give writs from before workflow states a state", func(m *Migrator) error {
+		return m.Backfill("writs", `!HAS(doc, "state")`, `{state: doc.public ? @published : @draft}`, obj{
+			"published": WritPublished,
+			"draft":     WritDraft,
+		})
+	}},
+	{8, "redirects collection and its index", func(m *Migrator) error {
+		if err := m.Collection("redirects", nil); err != nil {
+			return err
+		}
+		return m.HashIndex("redirects", []string{"writ"}, &driver.EnsureHashIndexOptions{})
+	}},
+	{9, "series collection and its indexes", func(m *Migrator) error {
+		if err := m.Collection("series", nil); err != nil {
+			return err
+		}
+		if err := m.HashIndex("series", []string{"slug"}, &driver.EnsureHashIndexOptions{Unique: true}); err != nil {
+			return err
+		}
+		if err := m.HashIndex("series", []string{"title"}, &driver.EnsureHashIndexOptions{Unique: true}); err != nil {
+			return err
+		}
+		return m.HashIndex("series", []string{"writs[*]"}, &driver.EnsureHashIndexOptions{})
+	}},
+	{10, "media collection and its indexes", func(m *Migrator) error {
+		if err := m.Collection("media", nil); err != nil {
+			return err
+		}
+		if err := m.SkipListIndex("media", []string{"uploaded"}, &driver.EnsureSkipListIndexOptions{}); err != nil {
+			return err
+		}
+		return m.HashIndex("media", []string{"writs[*]"}, &driver.EnsureHashIndexOptions{})
+	}},
+}
+
+func (m *Migrator) note(format string, args ...interface{}) {
+	if m.DryRun {
+		format = "  would " + format
+	} else {
+		format = "  " + format
+	}
+	fmt.Printf(format+"\n", args...)
+}
+
+// Collection create a collection if it isn't there yet
+func (m *Migrator) Collection(name string, options *driver.CreateCollectionOptions) error {
+	exists, err := DB.CollectionExists(nil, name)
+	if err != nil || exists {
+		return err
+	}
+	m.note("create collection %s", name)
+	if m.DryRun {
+		return nil
+	}
+	_, err = DB.CreateCollection(nil, name, options)
+	return err
+}
+
+// HashIndex make sure a collection has a hash index on some fields
+func (m *Migrator) HashIndex(collection string, fields []string, options *driver.EnsureHashIndexOptions) error {
+	m.note("ensure a hash index on %s (%s)", collection, strings.Join(fields, ", "))
+	if m.DryRun {
+		return nil
+	}
+	coll, err := DB.Collection(nil, collection)
+	if err == nil {
+		_, _, err = coll.EnsureHashIndex(nil, fields, options)
+	}
+	return err
+}
+
+// SkipListIndex make sure a collection has a skiplist index on some fields
+func (m *Migrator) SkipListIndex(collection string, fields []string, options *driver.EnsureSkipListIndexOptions) error {
+	m.note("ensure a skiplist index on %s (%s)", collection, strings.Join(fields, ", "))
+	if m.DryRun {
+		return nil
+	}
+	coll, err := DB.Collection(nil, collection)
+	if err == nil {
+		_, _, err = coll.EnsureSkipListIndex(nil, fields, options)
+	}
+	return err
+}
+
+// View create a view if it isn't there yet, using create to do so
+func (m *Migrator) View(name string, create func() error) error {
+	exists, err := DB.ViewExists(nil, name)
+	if err != nil || exists {
+		return err
+	}
+	m.note("create view %s", name)
+	if m.DryRun {
+		return nil
+	}
+	return create()
+}
+
+// Backfill update every doc in a collection matching an AQL filter with an AQL object,
+// both can use doc and any of the vars
+func (m *Migrator) Backfill(collection, filter, update string, vars obj) error {
+	if vars == nil {
+		vars = obj{}
+	}
+	vars["@collection"] = collection
+
+	exists, err := DB.CollectionExists(nil, collection)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// a dry run over a fresh database, there's nothing to backfill
+		return nil
+	}
+
+	var count int64
+	err = QueryOne(`FOR doc IN @@collection FILTER `+filter+` COLLECT WITH COUNT INTO n RETURN n`, vars, &count)
+	if err != nil {
+		return err
+	}
+	m.note("update %d document(s) in %s with %s", count, collection, update)
+	if m.DryRun || count == 0 {
+		return nil
+	}
+
+	_, err = DB.Query(
+		driver.WithWaitForSync(context.Background()),
+		`FOR doc IN @@collection FILTER `+filter+` UPDATE doc WITH `+update+` IN @@collection`,
+		vars,
+	)
+	return err
+}
+
+// appliedMigrations the versions of every migration that's been run before
+func appliedMigrations() (map[int]MigrationRecord, error) {
+	applied := map[int]MigrationRecord{}
+	exists, err := DB.CollectionExists(nil, MigrationsCollection)
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	results, err := Query(`FOR m IN @@migrations RETURN m`, obj{"@migrations": MigrationsCollection})
+	if err != nil {
+		if driver.IsNoMoreDocuments(err) {
+			return applied, nil
+		}
+		return applied, err
+	}
+	for _, result := range results {
+		var record MigrationRecord
+		if err = fromDoc(result, &record); err != nil {
+			return applied, err
+		}
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// pendingMigrations the migrations that haven't been run yet, in order
+func pendingMigrations(applied map[int]MigrationRecord) []Migration {
+	pending := []Migration{}
+	for _, migration := range Migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	return pending
+}
+
+// runMigrations apply every pending migration in order, stopping at the first one that fails
+func runMigrations(dryRun bool) error {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	pending := pendingMigrations(applied)
+	if len(pending) == 0 {
+		fmt.Println("migrations: the database is up to date")
+		return nil
+	}
+
+	m := &Migrator{DryRun: dryRun}
+	if !dryRun {
+		err = m.Collection(MigrationsCollection, &driver.CreateCollectionOptions{IsSystem: true, WaitForSync: true})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, migration := range pending {
+		fmt.Printf("migration %d - %s\n", migration.Version, migration.Name)
+		err = migration.Up(m)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		if dryRun {
+			continue
+		}
+
+		_, err = DB.Query(
+			driver.WithWaitForSync(context.Background()),
+			`INSERT @record INTO @@migrations`,
+			obj{
+				"@migrations": MigrationsCollection,
+				"record": MigrationRecord{
+					Key:     strconv.Itoa(migration.Version),
+					Version: migration.Version,
+					Name:    migration.Name,
+					Applied: time.Now(),
+				},
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) ran but couldn't be recorded: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// migrationStatus print every migration and whether/when it was applied
+func migrationStatus() error {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for _, migration := range Migrations {
+		if record, ok := applied[migration.Version]; ok {
+			fmt.Printf("%4d  applied %s  %s\n", migration.Version, record.Applied.Format(time.RFC3339), migration.Name)
+		} else {
+			fmt.Printf("%4d  pending                    %s\n", migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+func migrateCommand() *Command {
+	var dryRun, status bool
+	sc := flaggy.NewSubcommand("migrate")
+	sc.Description = "run any pending database migrations"
+	sc.Bool(&dryRun, "n", "dry-run", "only print what the migrations would do")
+	sc.Bool(&status, "s", "status", "list the migrations and whether they've been applied")
+
+	return &Command{
+		Subcommand: sc,
+		Prepare: func() {
+			MigrateOnConnect = false
+		},
+		Run: func() error {
+			if !usingArango() {
+				return ErrNeedsArangoDB
+			}
+			if status {
+				return migrationStatus()
+			}
+			return runMigrations(dryRun)
+		},
+	}
+}
@@ -0,0 +1,265 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// OGImageWidth and OGImageHeight the size of writs' social cards,
+	// the 1.91:1 both open graph and twitter's large cards expect
+	OGImageWidth  = 1200
+	OGImageHeight = 630
+)
+
+const ogPadding = 80
+
+var (
+	ogBackground = color.RGBA{0x1d, 0x1f, 0x27, 0xff}
+	ogAccent     = color.RGBA{0xe2, 0xb7, 0x14, 0xff}
+	ogText       = color.RGBA{0xf5, 0xf5, 0xf5, 0xff}
+	ogMuted      = color.RGBA{0xa0, 0xa4, 0xb8, 0xff}
+)
+
+// the go fonts are bundled with x/image, so cards look the same wherever the server runs
+var ogFonts struct {
+	once    sync.Once
+	regular *opentype.Font
+	bold    *opentype.Font
+	err     error
+}
+
+// ogFace a face of one of the bundled fonts at a size in pixels
+func ogFace(bold bool, size float64) (font.Face, error) {
+	ogFonts.once.Do(func() {
+		ogFonts.regular, ogFonts.err = opentype.Parse(goregular.TTF)
+		if ogFonts.err == nil {
+			ogFonts.bold, ogFonts.err = opentype.Parse(gobold.TTF)
+		}
+	})
+	if ogFonts.err != nil {
+		return nil, ogFonts.err
+	}
+	f := ogFonts.regular
+	if bold {
+		f = ogFonts.bold
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// fitText cut text down so it fits in width, with an ellipsis when anything was cut
+func fitText(face font.Face, text string, width int) string {
+	limit := fixed.I(width)
+	if font.MeasureString(face, text) <= limit {
+		return text
+	}
+	for len(text) != 0 {
+		_, size := utf8.DecodeLastRuneInString(text)
+		text = text[:len(text)-size]
+		if font.MeasureString(face, strings.TrimSpace(text)+"…") <= limit {
+			return strings.TrimSpace(text) + "…"
+		}
+	}
+	return ""
+}
+
+// wrapText break text into lines no wider than width, words too long for
+// a line of their own are broken wherever they have to be
+func wrapText(face font.Face, text string, width int) []string {
+	limit := fixed.I(width)
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if len(line) != 0 {
+			candidate = line + " " + word
+		}
+		if font.MeasureString(face, candidate) <= limit {
+			line = candidate
+			continue
+		}
+		if len(line) != 0 {
+			lines = append(lines, line)
+		}
+		line = word
+		for font.MeasureString(face, line) > limit {
+			cut := len(line)
+			for cut > 0 && font.MeasureString(face, line[:cut]) > limit {
+				_, size := utf8.DecodeLastRuneInString(line[:cut])
+				cut -= size
+			}
+			if cut == 0 {
+				break
+			}
+			lines = append(lines, line[:cut])
+			line = line[cut:]
+		}
+	}
+	if len(line) != 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// drawText write a line of text with its baseline at y
+func drawText(dst draw.Image, face font.Face, col color.Color, x, y int, text string) {
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(col), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
+
+// renderOGImage draw a writ's social card: the site's name, the title as big as fits,
+// who wrote it and its tags
+func renderOGImage(w *Writ) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, OGImageWidth, OGImageHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(ogBackground), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 16, OGImageHeight), image.NewUniform(ogAccent), image.Point{}, draw.Src)
+
+	width := OGImageWidth - 2*ogPadding
+
+	site, err := ogFace(true, 30)
+	if err != nil {
+		return nil, err
+	}
+	defer site.Close()
+	name := AppName
+	if len(name) == 0 {
+		name = AppDomain
+	}
+	drawText(img, site, ogAccent, ogPadding, ogPadding+24, fitText(site, name, width/2))
+	if len(AppDomain) != 0 && AppDomain != name {
+		domain := fitText(site, AppDomain, width/2)
+		drawText(img, site, ogMuted, OGImageWidth-ogPadding-font.MeasureString(site, domain).Ceil(), ogPadding+24, domain)
+	}
+
+	// the biggest size the title fits in three lines at, or the smallest size cut short
+	var title font.Face
+	var lines []string
+	for size := 72.0; size >= 44; size -= 7 {
+		if title, err = ogFace(true, size); err != nil {
+			return nil, err
+		}
+		lines = wrapText(title, w.Title, width)
+		if len(lines) <= 3 || size-7 < 44 {
+			break
+		}
+		title.Close()
+	}
+	defer title.Close()
+	if len(lines) > 3 {
+		lines = append(lines[:2], fitText(title, strings.Join(lines[2:], " "), width))
+	}
+	lineHeight := title.Metrics().Height.Ceil()
+	y := ogPadding + 80 + title.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		drawText(img, title, ogText, ogPadding, y, line)
+		y += lineHeight
+	}
+
+	meta, err := ogFace(false, 30)
+	if err != nil {
+		return nil, err
+	}
+	defer meta.Close()
+	if len(w.Author) != 0 {
+		drawText(img, meta, ogMuted, ogPadding, OGImageHeight-ogPadding-50, fitText(meta, "by "+w.Author, width))
+	}
+	if len(w.Tags) != 0 {
+		tags := make([]string, len(w.Tags))
+		for i, tag := range w.Tags {
+			tags[i] = "#" + tag
+		}
+		drawText(img, meta, ogAccent, ogPadding, OGImageHeight-ogPadding, fitText(meta, strings.Join(tags, "  "), width))
+	}
+
+	var buf bytes.Buffer
+	err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+// ogVersion what changes whenever anything on a writ's card might have, when it was last edited,
+// _rev isn't used as the queries most visitors go through leave it out
+func ogVersion(w *Writ) string {
+	return strconv.FormatInt(w.LastModified().UnixNano(), 36)
+}
+
+// ogImageURL where a writ's social card is, versioned so sites that cache them pick up changes
+func ogImageURL(w *Writ) string {
+	return siteURL() + "/writ/" + url.PathEscape(w.Slug) + "/og.png?v=" + url.QueryEscape(ogVersion(w))
+}
+
+var (
+	ogCache     = map[string][]byte{}
+	ogCacheLock sync.RWMutex
+)
+
+// WritOGImage a writ's social card as a png, from the cache when the writ hasn't changed since
+func WritOGImage(w *Writ) ([]byte, error) {
+	id := w.Key + "-" + ogVersion(w)
+	ogCacheLock.RLock()
+	card, ok := ogCache[id]
+	ogCacheLock.RUnlock()
+	if ok {
+		return card, nil
+	}
+
+	card, err := renderOGImage(w)
+	if err != nil {
+		return nil, err
+	}
+
+	ogCacheLock.Lock()
+	if len(ogCache) > 512 {
+		// old versions pile up as writs change, start over
+		ogCache = map[string][]byte{}
+	}
+	ogCache[id] = card
+	ogCacheLock.Unlock()
+	return card, nil
+}
+
+func initOGImages() {
+	Server.GET("/writ/:slug/og.png", func(c ctx) error {
+		user, err := CredentialCheck(c)
+		if err != nil {
+			user = nil
+		}
+		q := &WritQuery{
+			Slug:      c.Param("slug"),
+			Omissions: []string{"markdown", "content", "injection", "likedby", "viewedby"},
+		}
+		q.RestrictTo(user)
+		writ, err := q.ExecOne()
+		if err != nil {
+			if isNotFound(err) {
+				return Err404NotFound
+			}
+			return ServerDBError.Send(c)
+		}
+
+		card, err := WritOGImage(&writ)
+		if err != nil {
+			if DevMode {
+				fmt.Println("GET /writ/:slug/og.png - couldn't draw the card: ", err)
+			}
+			return ServerDBError.Send(c)
+		}
+		return serveCached(c, card, "image/png", `"`+writ.Key+"-"+ogVersion(&writ)+`"`, writ.LastModified())
+	})
+
+	fmt.Println("Open Graph Image Service Started")
+}
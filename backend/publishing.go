@@ -0,0 +1,178 @@
+package backend
+
+import (
+	"fmt"
+	"time"
+)
+
+// The workflow states a writ moves through on its way to the public
+const (
+	// WritDraft a writ still being written, never public
+	WritDraft = "draft"
+	// WritReview a writ that's done but waiting on a second pair of eyes
+	WritReview = "review"
+	// WritScheduled a writ that goes public by itself once its .PublishAt time comes
+	WritScheduled = "scheduled"
+	// WritPublished a public writ
+	WritPublished = "published"
+)
+
+var (
+	// PublishScheduler the timer waking up to publish scheduled writs
+	PublishScheduler *time.Timer
+	// publishWake nudges the scheduler to recheck, for when schedules change
+	publishWake = make(chan struct{}, 1)
+)
+
+// validWritState check that a state is one of the known workflow states
+func validWritState(state string) bool {
+	switch state {
+	case WritDraft, WritReview, WritScheduled, WritPublished:
+		return true
+	}
+	return false
+}
+
+// syncState make .State and .Public agree with each other,
+// a published state means public and anything else means private,
+// writs without a state get one based on .Public and .PublishAt
+func (w *Writ) syncState() error {
+	if len(w.State) != 0 && !validWritState(w.State) {
+		return ErrInvalidWritState
+	}
+
+	if w.State == WritScheduled && w.PublishAt.IsZero() {
+		return ErrMissingPublishAt
+	}
+
+	if len(w.State) == 0 {
+		if w.Public {
+			w.State = WritPublished
+		} else if !w.PublishAt.IsZero() && w.PublishAt.After(time.Now()) {
+			w.State = WritScheduled
+		} else {
+			w.State = WritDraft
+		}
+	}
+
+	w.Public = w.State == WritPublished
+	return nil
+}
+
+// claimNotification mark a writ's subscribers as notified,
+// returns true only for the first caller so notifications go out exactly once
+func claimNotification(writKey string) bool {
+	claimed, err := Stores.Writs.ClaimNotification(writKey)
+	return err == nil && claimed
+}
+
+// scheduledWrits every writ that's waiting to be published
+func scheduledWrits() ([]Writ, error) {
+	return Stores.Writs.Query(&WritQuery{
+		State:              WritScheduled,
+		IncludePrivate:     true,
+		IncludeMembersOnly: true,
+		DontSort:           true,
+		Omissions:          []string{"markdown", "content"},
+	})
+}
+
+// publishDueWrits flip every scheduled writ whose time has come to published
+func publishDueWrits() error {
+	writs, err := scheduledWrits()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	published := []*Writ{}
+	for i := range writs {
+		writ := &writs[i]
+		if writ.State != WritScheduled || writ.PublishAt.After(now) {
+			continue
+		}
+		_, err = Stores.Writs.Update(writ.Key, writ.EditRev, obj{
+			"state":    WritPublished,
+			"public":   true,
+			"notified": true,
+		})
+		if err == ErrStaleRevision {
+			// it was edited in the meantime, the next run will pick it up again if need be
+			continue
+		} else if err != nil {
+			return err
+		}
+		published = append(published, writ)
+
+		if DevMode {
+			fmt.Println("Scheduled writ is now published: ", writ.Title)
+		}
+		if !writ.Notified {
+			go notifySubscribers(writ.Key)
+		}
+	}
+
+	if len(published) != 0 {
+		writsChanged(published...)
+	}
+	return nil
+}
+
+// nextScheduledPublish find when the next scheduled writ is due, if there's any
+func nextScheduledPublish() (time.Time, bool) {
+	var next time.Time
+	writs, err := scheduledWrits()
+	if err != nil {
+		return next, false
+	}
+	for _, writ := range writs {
+		if writ.State == WritScheduled && (next.IsZero() || writ.PublishAt.Before(next)) {
+			next = writ.PublishAt
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// wakePublishScheduler let the scheduler know the schedule might have changed
+func wakePublishScheduler() {
+	select {
+	case publishWake <- struct{}{}:
+	default:
+	}
+}
+
+func startPublishScheduler() {
+	PublishScheduler = time.NewTimer(0)
+	go func() {
+		for {
+			select {
+			case <-PublishScheduler.C:
+			case <-publishWake:
+				if !PublishScheduler.Stop() {
+					select {
+					case <-PublishScheduler.C:
+					default:
+					}
+				}
+			}
+
+			err := publishDueWrits()
+			if err != nil && DevMode {
+				fmt.Println("publish scheduler: trouble publishing scheduled writs - ", err)
+			}
+
+			// check back at least every minute, incase the db was unreachable
+			wait := time.Minute
+			if next, ok := nextScheduledPublish(); ok {
+				if until := time.Until(next); until < wait {
+					wait = until
+				}
+			}
+			if wait < time.Second {
+				wait = time.Second
+			}
+			PublishScheduler.Reset(wait)
+		}
+	}()
+	fmt.Println("writ publish scheduler started")
+}
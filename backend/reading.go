@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// DefaultWordsPerMinute how fast readers are reckoned to read, unless the config says otherwise
+	DefaultWordsPerMinute = 230
+	// DefaultExcerptLength roughly how many characters an auto excerpt has, unless the config says otherwise
+	DefaultExcerptLength = 200
+)
+
+// isWord whether a bit of text between spaces has any letters or numbers in it,
+// so heading anchors and stray punctuation aren't counted
+func isWord(field string) bool {
+	for _, r := range field {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimExcerpt cut text down to about length characters, at a word boundary
+func trimExcerpt(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	cut := length
+	for cut > length/2 && !unicode.IsSpace(runes[cut]) {
+		cut--
+	}
+	if cut == length/2 {
+		cut = length
+	}
+	return strings.TrimRightFunc(string(runes[:cut]), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…"
+}
+
+// readingStats count the words in rendered content, reckon how many minutes it takes to read,
+// and take the first paragraph's text as an excerpt
+func readingStats(content string) (words int, minutes int, excerpt string) {
+	wpm, length := DefaultWordsPerMinute, DefaultExcerptLength
+	if Conf != nil {
+		if Conf.Markdown.WordsPerMinute > 0 {
+			wpm = Conf.Markdown.WordsPerMinute
+		}
+		if Conf.Markdown.ExcerptLength > 0 {
+			length = Conf.Markdown.ExcerptLength
+		}
+	}
+
+	// paragraphs in callouts, figures and the like aren't where a writ starts
+	aside := 0
+	skip := 0
+	inParagraph := false
+	var paragraph strings.Builder
+
+	z := html.NewTokenizer(strings.NewReader(content))
+	for done := false; !done; {
+		switch z.Next() {
+		case html.ErrorToken:
+			done = true
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style":
+				skip++
+			case "aside", "figure", "details", "nav":
+				aside++
+			case "p":
+				inParagraph = aside == 0 && len(excerpt) == 0
+				paragraph.Reset()
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style":
+				if skip > 0 {
+					skip--
+				}
+			case "aside", "figure", "details", "nav":
+				if aside > 0 {
+					aside--
+				}
+			case "p":
+				if inParagraph {
+					excerpt = strings.Join(strings.Fields(paragraph.String()), " ")
+				}
+				inParagraph = false
+			}
+		case html.TextToken:
+			if skip != 0 {
+				continue
+			}
+			text := string(z.Text())
+			for _, field := range strings.Fields(text) {
+				if isWord(field) {
+					words++
+				}
+			}
+			if inParagraph {
+				paragraph.WriteString(text)
+			}
+		}
+	}
+
+	if words != 0 {
+		minutes = (words + wpm - 1) / wpm
+	}
+	return words, minutes, trimExcerpt(excerpt, length)
+}
@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// redirect an old path and the writ it leads to now
+type redirect struct {
+	Key     string    `json:"_key,omitempty"`
+	Path    string    `json:"path"`
+	Writ    string    `json:"writ"`
+	Created time.Time `json:"created"`
+}
+
+// redirectPath tidy a path or url up so /a/b, /a/b/ and https://site/a/b all look the same
+func redirectPath(p string) string {
+	if u, err := url.Parse(p); err == nil && len(u.Path) != 0 {
+		p = u.Path
+	}
+	return path.Clean("/" + p)
+}
+
+// redirectKey a document key for a path, paths can hold characters keys can't
+func redirectKey(p string) string {
+	sum := sha1.Sum([]byte(p))
+	return hex.EncodeToString(sum[:])
+}
+
+// writRedirects remember the paths a writ used to live at, so old links still find it:
+// its previous slug if that's changed, and any aliases it came with
+func writRedirects(w *Writ, oldSlug string) {
+	paths := []string{}
+	if len(oldSlug) != 0 && len(w.Slug) != 0 && oldSlug != w.Slug {
+		paths = append(paths, "/writ/"+oldSlug)
+	}
+	for _, alias := range w.Aliases {
+		if len(strings.Trim(alias, "/")) != 0 {
+			paths = append(paths, alias)
+		}
+	}
+
+	for _, p := range paths {
+		err := Stores.Redirects.Add(p, w.Key)
+		if err != nil && DevMode {
+			fmt.Println("couldn't keep a redirect from ", p, " to writ ", w.Key, " - ", err)
+		}
+	}
+}
+
+// redirectedWrit the writ an old path leads to, if the user can see it
+func redirectedWrit(key string, user *User) (Writ, error) {
+	q := &WritQuery{Key: key}
+	q.RestrictTo(user)
+	return q.ExecOne()
+}
+
+// redirectOldPath send requests for paths that used to be writs to where they are now,
+// it reports whether it did
+func redirectOldPath(c ctx) bool {
+	req := c.Request()
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+
+	key, err := Stores.Redirects.Lookup(req.URL.Path)
+	if err != nil {
+		return false
+	}
+
+	user, err := CredentialCheck(c)
+	if err != nil {
+		user = nil
+	}
+	writ, err := redirectedWrit(key, user)
+	if err != nil {
+		return false
+	}
+	return c.Redirect(301, writ.GetLink()) == nil
+}
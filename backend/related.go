@@ -0,0 +1,220 @@
+package backend
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// RelatedCount how many related writs a writ gets
+	RelatedCount = 5
+	// RelatedExpiry how long related writs are cached for, likes come and go in the meantime
+	RelatedExpiry = 6 * time.Hour
+)
+
+// how much each signal counts towards two writs being related
+const (
+	relatedTagWeight     = 3.0
+	relatedLikeWeight    = 2.0
+	relatedRecencyWeight = 0.5
+)
+
+// RelatedWrit a writ worth reading after another one
+type RelatedWrit struct {
+	Key         string   `json:"_key" msgpack:"_key"`
+	Title       string   `json:"title" msgpack:"title"`
+	Slug        string   `json:"slug" msgpack:"slug"`
+	Description string   `json:"description,omitempty" msgpack:"description,omitempty"`
+	Tags        []string `json:"tags" msgpack:"tags"`
+	URL         string   `json:"url" msgpack:"url"`
+	Score       float64  `json:"score" msgpack:"score"`
+}
+
+type cachedRelated struct {
+	Writs   []RelatedWrit
+	Tags    []string
+	Expires time.Time
+}
+
+var (
+	relatedCache     = map[string]*cachedRelated{}
+	relatedCacheLock sync.RWMutex
+)
+
+// forgetRelated drop the cached related writs a change to a writ could affect: its own,
+// those it's among and those of writs sharing a tag with it, they'll be worked out again when next needed
+func forgetRelated(key string, tags []string) {
+	relatedCacheLock.Lock()
+	defer relatedCacheLock.Unlock()
+	delete(relatedCache, key)
+	for other, cached := range relatedCache {
+		if overlap(cached.Tags, tags) != 0 {
+			delete(relatedCache, other)
+			continue
+		}
+		for _, r := range cached.Writs {
+			if r.Key == key {
+				delete(relatedCache, other)
+				break
+			}
+		}
+	}
+}
+
+// overlap how many items two lists have in common
+func overlap(a, b []string) int {
+	n := 0
+	for _, item := range a {
+		if stringsContain(b, item) {
+			n++
+		}
+	}
+	return n
+}
+
+// relatedScore how related candidate is to writ: shared tags (jaccard), readers who liked
+// both (cosine) and, to break ties and favour fresh writing, how recent the candidate is
+func relatedScore(writ, candidate *Writ, now time.Time) float64 {
+	tags := 0.0
+	if shared := overlap(writ.Tags, candidate.Tags); shared != 0 {
+		tags = float64(shared) / float64(len(writ.Tags)+len(candidate.Tags)-shared)
+	}
+
+	likes := 0.0
+	if shared := overlap(writ.LikedBy, candidate.LikedBy); shared != 0 {
+		likes = float64(shared) / math.Sqrt(float64(len(writ.LikedBy)*len(candidate.LikedBy)))
+	}
+
+	if tags == 0 && likes == 0 {
+		return 0
+	}
+
+	age := now.Sub(candidate.Created).Hours() / 24
+	if age < 0 {
+		age = 0
+	}
+	recency := 1 / (1 + age/30)
+
+	return relatedTagWeight*tags + relatedLikeWeight*likes + relatedRecencyWeight*recency
+}
+
+// computeRelated rank the writs anyone may read that share a tag or a liker with a writ
+// by how related they are to it
+func computeRelated(key string) ([]RelatedWrit, *Writ, error) {
+	// the whole writ, queries usually leave out who liked it
+	writ, err := WritByKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q := &WritQuery{
+		Omissions: []string{"markdown", "content", "injection", "viewedby"},
+		relatedTo: &writ,
+	}
+	q.RestrictTo(nil)
+	candidates, err := Stores.Writs.Query(q)
+	if err != nil && !isNotFound(err) {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	related := []RelatedWrit{}
+	for i := range candidates {
+		candidate := &candidates[i]
+		score := relatedScore(&writ, candidate, now)
+		if score == 0 {
+			continue
+		}
+		related = append(related, RelatedWrit{
+			Key:         candidate.Key,
+			Title:       candidate.Title,
+			Slug:        candidate.Slug,
+			Description: candidate.Summary(),
+			Tags:        candidate.Tags,
+			URL:         candidate.GetLink(),
+			Score:       score,
+		})
+	}
+
+	sort.SliceStable(related, func(i, j int) bool { return related[i].Score > related[j].Score })
+	if len(related) > RelatedCount {
+		related = related[:RelatedCount]
+	}
+	return related, &writ, nil
+}
+
+// RelatedWrits the writs most related to a writ, from the cache when they're there
+func RelatedWrits(key string) ([]RelatedWrit, error) {
+	relatedCacheLock.RLock()
+	cached, ok := relatedCache[key]
+	relatedCacheLock.RUnlock()
+	if ok && time.Now().Before(cached.Expires) {
+		return cached.Writs, nil
+	}
+
+	related, writ, err := computeRelated(key)
+	if err != nil {
+		return nil, err
+	}
+
+	relatedCacheLock.Lock()
+	if len(relatedCache) > 4096 {
+		// it's grown too big, start over
+		relatedCache = map[string]*cachedRelated{}
+	}
+	relatedCache[key] = &cachedRelated{Writs: related, Tags: writ.Tags, Expires: time.Now().Add(RelatedExpiry)}
+	relatedCacheLock.Unlock()
+	return related, nil
+}
+
+// refreshRelated work out a writ's related writs again, after it's changed
+func refreshRelated(key string) {
+	_, err := RelatedWrits(key)
+	if err != nil && DevMode {
+		fmt.Println("couldn't work out the related writs for ", key, " - ", err)
+	}
+}
+
+// relatedPageData the related writs, ready for the writ template
+func relatedPageData(writ *Writ) []obj {
+	related, err := RelatedWrits(writ.Key)
+	if err != nil || len(related) == 0 {
+		return nil
+	}
+	list := make([]obj, len(related))
+	for i, r := range related {
+		list[i] = obj{
+			"Title":       html.EscapeString(r.Title),
+			"Description": html.EscapeString(r.Description),
+			"URL":         r.URL,
+		}
+	}
+	return list
+}
+
+func initRelated() {
+	Server.GET("/related/:slug", func(c ctx) error {
+		user, err := CredentialCheck(c)
+		if err != nil {
+			user = nil
+		}
+		q := &WritQuery{Slug: c.Param("slug")}
+		q.RestrictTo(user)
+		writ, err := q.ExecOne()
+		if err != nil {
+			return NoSuchWrit.Send(c)
+		}
+
+		related, err := RelatedWrits(writ.Key)
+		if err != nil {
+			return ServerDBError.Send(c)
+		}
+		return c.Msgpack(200, related)
+	})
+
+	fmt.Println("Related Writs Service Started")
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestComputeRelated(t *testing.T) {
+	setupTestStore(t)
+	writs := map[string]*Writ{}
+	for _, w := range []*Writ{
+		{Title: "first", Tags: []string{"go"}},
+		{Title: "same tag", Tags: []string{"go", "web"}},
+		{Title: "unrelated", Tags: []string{"rust"}},
+		{Title: "same reader", Tags: []string{"cooking"}},
+		{Title: "same tag but private", Tags: []string{"go"}},
+	} {
+		w.Author, w.Markdown = "author", "text"
+		w.Public = w.Title != "same tag but private"
+		if err := InitWrit(w); err != nil {
+			t.Fatal(err)
+		}
+		writs[w.Title] = w
+	}
+	for _, title := range []string{"first", "same reader"} {
+		if err := Stores.Writs.ToggleLike(writs[title].Slug, "reader"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	related, _, err := computeRelated(writs["first"].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, r := range related {
+		got = append(got, r.Title)
+	}
+	sort.Strings(got)
+	if want := []string{"same reader", "same tag"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("related to first: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,307 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WritRevision a prior version of a writ, kept whenever a writ is edited
+type WritRevision struct {
+	Key         string    `json:"_key,omitempty" msgpack:"_key,omitempty"`
+	WritKey     string    `json:"writkey,omitempty" msgpack:"writkey,omitempty"`
+	Title       string    `json:"title,omitempty" msgpack:"title,omitempty"`
+	Markdown    string    `json:"markdown,omitempty" msgpack:"markdown,omitempty"`
+	Description string    `json:"description,omitempty" msgpack:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty" msgpack:"tags,omitempty"`
+	Editor      string    `json:"editor,omitempty" msgpack:"editor,omitempty"`
+	Time        time.Time `json:"time,omitempty" msgpack:"time,omitempty"`
+	Replaced    time.Time `json:"replaced,omitempty" msgpack:"replaced,omitempty"`
+}
+
+// DiffLine one line of a line-level diff, Op is one of "=", "+" or "-"
+type DiffLine struct {
+	Op   string `json:"op" msgpack:"op"`
+	Text string `json:"text" msgpack:"text"`
+}
+
+// revisionWorthy does next change anything about current that's worth keeping a copy of
+func revisionWorthy(current, next *Writ) bool {
+	if len(current.Key) == 0 {
+		return false
+	}
+	if len(next.Markdown) != 0 && next.Markdown != current.Markdown {
+		return true
+	}
+	if len(next.Title) != 0 && next.Title != current.Title {
+		return true
+	}
+	if len(next.Tags) != len(current.Tags) {
+		return true
+	}
+	for i, tag := range next.Tags {
+		if current.Tags[i] != tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveRevision store the writ as it is now, as a revision of itself
+func SaveRevision(w *Writ) error {
+	rev := WritRevision{
+		WritKey:     w.Key,
+		Title:       w.Title,
+		Markdown:    w.Markdown,
+		Description: w.Description,
+		Tags:        w.Tags,
+		Editor:      w.Editor,
+		Time:        w.Created,
+		Replaced:    time.Now(),
+	}
+	if len(rev.Editor) == 0 {
+		rev.Editor = w.Author
+	}
+	if len(w.Edits) != 0 {
+		rev.Time = w.Edits[len(w.Edits)-1]
+	}
+
+	return Stores.Revisions.Create(&rev)
+}
+
+// RevisionByKey retrieve a revision using its db document key
+func RevisionByKey(key string) (WritRevision, error) {
+	return Stores.Revisions.ByKey(key)
+}
+
+// RevisionsByWrit list a writ's revisions, newest first and without their markdown
+func RevisionsByWrit(writKey string) ([]WritRevision, error) {
+	return Stores.Revisions.ByWrit(writKey)
+}
+
+// writVersion get either a stored revision or, for "current", the live version of a writ
+func writVersion(writKey, revKey string) (WritRevision, error) {
+	if revKey == "current" {
+		writ, err := WritByKey(writKey)
+		if err != nil {
+			return WritRevision{}, err
+		}
+		rev := WritRevision{
+			Key:         "current",
+			WritKey:     writ.Key,
+			Title:       writ.Title,
+			Markdown:    writ.Markdown,
+			Description: writ.Description,
+			Tags:        writ.Tags,
+			Editor:      writ.Editor,
+			Time:        writ.Created,
+		}
+		if len(writ.Edits) != 0 {
+			rev.Time = writ.Edits[len(writ.Edits)-1]
+		}
+		return rev, nil
+	}
+
+	rev, err := RevisionByKey(revKey)
+	if err == nil && rev.WritKey != writKey {
+		err = NoSuchRevision
+	}
+	return rev, err
+}
+
+// RestoreRevision bring back an old version of a writ as a brand new edit,
+// only its words change, it stays as published (or not) as it is now
+func RestoreRevision(writKey, revKey string, editor *User) error {
+	rev, err := RevisionByKey(revKey)
+	if err != nil || rev.WritKey != writKey {
+		return NoSuchRevision
+	}
+
+	writ, err := WritByKey(writKey)
+	if err != nil {
+		return NoSuchWrit
+	}
+	writ.Title = rev.Title
+	writ.Markdown = rev.Markdown
+	writ.Content = ""
+	writ.Description = rev.Description
+	writ.Tags = rev.Tags
+	writ.Editor = editor.Username
+	return InitWrit(&writ)
+}
+
+// removeWritRevisions clear out the revision history of a writ
+func removeWritRevisions(writKey string) error {
+	return Stores.Revisions.RemoveWrit(writKey)
+}
+
+// maxDiffEdits how many lines a diff may add and remove before it stops looking
+// for the shortest edit and swaps the changed lines out wholesale
+const maxDiffEdits = 1000
+
+// DiffLines produce a line-level diff turning a into b
+func DiffLines(a, b string) []DiffLine {
+	al := strings.Split(a, "\n")
+	bl := strings.Split(b, "\n")
+
+	// the lines both start and end with don't need searching through
+	prefix := 0
+	for prefix < len(al) && prefix < len(bl) && al[prefix] == bl[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(al)-prefix && suffix < len(bl)-prefix && al[len(al)-1-suffix] == bl[len(bl)-1-suffix] {
+		suffix++
+	}
+
+	diff := make([]DiffLine, 0, len(al)+len(bl))
+	for _, line := range al[:prefix] {
+		diff = append(diff, DiffLine{Op: "=", Text: line})
+	}
+	diff = append(diff, myersDiff(al[prefix:len(al)-suffix], bl[prefix:len(bl)-suffix])...)
+	for _, line := range al[len(al)-suffix:] {
+		diff = append(diff, DiffLine{Op: "=", Text: line})
+	}
+	return diff
+}
+
+// myersDiff the shortest edit turning a into b, found with Myers' O(ND) algorithm
+func myersDiff(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxDiffEdits {
+		limit = maxDiffEdits
+	}
+
+	// v[off+k] is how far along a the furthest path on diagonal k got,
+	// trace keeps v's diagonals -d to d as they were before each round d
+	off := limit + 1
+	v := make([]int, 2*limit+3)
+	trace := [][]int{}
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	diff := make([]DiffLine, 0, n+m)
+	if !found {
+		for _, line := range a {
+			diff = append(diff, DiffLine{Op: "-", Text: line})
+		}
+		for _, line := range b {
+			diff = append(diff, DiffLine{Op: "+", Text: line})
+		}
+		return diff
+	}
+
+	// walk back from the end, so the diff comes out reversed
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && prev[d+k-1] < prev[d+k+1]) {
+			prevK = k + 1
+		}
+		prevX := prev[d+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			diff = append(diff, DiffLine{Op: "=", Text: a[x]})
+		}
+		if x == prevX {
+			diff = append(diff, DiffLine{Op: "+", Text: b[y-1]})
+		} else {
+			diff = append(diff, DiffLine{Op: "-", Text: a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 {
+		x--
+		diff = append(diff, DiffLine{Op: "=", Text: a[x]})
+	}
+
+	for i, j := 0, len(diff)-1; i < j; i, j = i+1, j-1 {
+		diff[i], diff[j] = diff[j], diff[i]
+	}
+	return diff
+}
+
+func initRevisions() {
+	Server.GET("/writ-revisions/:writ", AdminHandle(func(c ctx, user *User) error {
+		key := c.Param("writ")
+		if len(key) < 1 {
+			return BadRequestError.Send(c)
+		}
+
+		revs, err := RevisionsByWrit(key)
+		if err != nil {
+			return ServerDBError.Send(c)
+		}
+		return c.Msgpack(200, revs)
+	}))
+
+	Server.GET("/writ-revision/:writ/:rev", AdminHandle(func(c ctx, user *User) error {
+		rev, err := writVersion(c.Param("writ"), c.Param("rev"))
+		if err != nil {
+			return NoSuchRevision.Send(c)
+		}
+		return c.Msgpack(200, rev)
+	}))
+
+	Server.GET("/writ-diff/:writ/:from/:to", AdminHandle(func(c ctx, user *User) error {
+		writKey := c.Param("writ")
+
+		from, err := writVersion(writKey, c.Param("from"))
+		if err != nil {
+			return NoSuchRevision.Send(c)
+		}
+		to, err := writVersion(writKey, c.Param("to"))
+		if err != nil {
+			return NoSuchRevision.Send(c)
+		}
+
+		return c.Msgpack(200, obj{
+			"from":  from.Key,
+			"to":    to.Key,
+			"title": DiffLines(from.Title, to.Title),
+			"tags":  DiffLines(strings.Join(from.Tags, "\n"), strings.Join(to.Tags, "\n")),
+			"diff":  DiffLines(from.Markdown, to.Markdown),
+		})
+	}))
+
+	Server.POST("/writ-restore/:writ/:rev", AdminHandle(func(c ctx, user *User) error {
+		err := RestoreRevision(c.Param("writ"), c.Param("rev"), user)
+		if err != nil {
+			if cr, ok := err.(*CodedResponse); ok {
+				return cr.Send(c)
+			}
+			if DevMode {
+				fmt.Println("POST /writ-restore - restoring failed: ", err)
+			}
+			return ServerDBError.Send(c)
+		}
+		return SuccessMsg.Send(c)
+	}))
+
+	fmt.Println("Writ Revision Service Started")
+}
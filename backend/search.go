@@ -0,0 +1,194 @@
+package backend
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/arangodb/go-driver"
+)
+
+// WritSearchView the ArangoSearch view used for full-text search over writs
+const WritSearchView = "writsearch"
+
+// SnippetLength roughly how many characters of text a search snippet shows
+const SnippetLength = 220
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// ensureSearchView create the ArangoSearch view over the writs collection
+func ensureSearchView() error {
+	text := driver.ArangoSearchElementProperties{Analyzers: []string{"text_en"}}
+	_, err := DB.CreateArangoSearchView(nil, WritSearchView, &driver.ArangoSearchViewProperties{
+		Links: driver.ArangoSearchLinks{
+			"writs": driver.ArangoSearchElementProperties{
+				Fields: driver.ArangoSearchFields{
+					"title":       text,
+					"description": text,
+					"markdown":    text,
+					"tags":        driver.ArangoSearchElementProperties{Analyzers: []string{"identity"}},
+				},
+			},
+		},
+	})
+	return err
+}
+
+// searchClause the SEARCH part of an AQL query looking for terms in writs
+func searchClause(search string, vars obj) string {
+	vars["search"] = search
+	vars["searchwords"] = searchTerms(search)
+	return `SEARCH ANALYZER(
+		writ.title IN TOKENS(@search, "text_en") ||
+		writ.description IN TOKENS(@search, "text_en") ||
+		writ.markdown IN TOKENS(@search, "text_en"),
+		"text_en"
+	) || writ.tags IN @searchwords `
+}
+
+// searchTerms split a search up into lowercase words
+func searchTerms(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '-' && r != '_'
+	})
+}
+
+// plainText strip html tags and collapse the whitespace of rendered content
+func plainText(content string) string {
+	text := html.UnescapeString(htmlTagRegexp.ReplaceAllString(content, " "))
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// searchSnippet a short bit of text around the first match of any of the terms,
+// html escaped, with the matches wrapped in <mark>
+func searchSnippet(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// some runes change size when lowercased, so fall back to matching as is
+		lower = text
+	}
+
+	first := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, term); i != -1 && (first == -1 || i < first) {
+			first = i
+		}
+	}
+
+	start := 0
+	if first > SnippetLength/3 {
+		start = first - SnippetLength/3
+		for start > 0 && text[start] != ' ' {
+			start--
+		}
+	}
+	end := start + SnippetLength
+	if end >= len(text) {
+		end = len(text)
+	} else {
+		for end < len(text) && text[end] != ' ' {
+			end++
+		}
+	}
+
+	snippet := text[start:end]
+	lowsnippet := lower[start:end]
+
+	var out strings.Builder
+	if start > 0 {
+		out.WriteString("… ")
+	}
+	last := 0
+	for i := 0; i < len(snippet); {
+		matched := 0
+		for _, term := range terms {
+			if len(term) != 0 && strings.HasPrefix(lowsnippet[i:], term) && len(term) > matched {
+				matched = len(term)
+			}
+		}
+		if matched == 0 {
+			i++
+			continue
+		}
+		out.WriteString(html.EscapeString(snippet[last:i]))
+		out.WriteString("<mark>" + html.EscapeString(snippet[i:i+matched]) + "</mark>")
+		i += matched
+		last = i
+	}
+	out.WriteString(html.EscapeString(snippet[last:]))
+	if end < len(text) {
+		out.WriteString(" …")
+	}
+	return out.String()
+}
+
+// highlightWrit give a search result its snippet, from the description if it matches, otherwise the content
+func highlightWrit(w *Writ, terms []string) {
+	source := w.Content
+	if len(source) == 0 {
+		source = string(renderMarkdown([]byte(w.Markdown), true))
+	}
+	text := plainText(source)
+
+	desc := strings.ToLower(w.Description)
+	for _, term := range terms {
+		if strings.Contains(desc, term) {
+			text = w.Description
+			break
+		}
+	}
+	w.Snippet = searchSnippet(text, terms)
+}
+
+func initSearch() {
+	Server.GET("/search", func(c ctx) error {
+		search := strings.TrimSpace(c.QueryParam("q"))
+		if len(search) < 2 || len(search) > 200 {
+			return BadRequestError.Send(c)
+		}
+
+		var page, count int64 = 0, 20
+		var err error
+		if p := c.QueryParam("page"); len(p) != 0 {
+			if page, err = strconv.ParseInt(p, 10, 64); err != nil || page < 0 {
+				return BadRequestError.Send(c)
+			}
+		}
+		if n := c.QueryParam("count"); len(n) != 0 {
+			if count, err = strconv.ParseInt(n, 10, 64); err != nil || count < 1 {
+				return BadRequestError.Send(c)
+			}
+		}
+		if count > 200 {
+			return RequestQueryOverLimitMembers.Send(c)
+		}
+
+		q := &WritQuery{
+			Search: search,
+			Limit:  []int64{page * count, count},
+		}
+
+		user, err := CredentialCheck(c)
+		if err != nil || user == nil {
+			if count > 50 {
+				return RequestQueryOverLimit.Send(c)
+			}
+			user = nil
+		}
+		q.RestrictTo(user)
+
+		writs, err := q.Exec()
+		if err != nil {
+			return ServerDBError.Send(c)
+		}
+		for i := range writs {
+			writs[i].Content = ""
+		}
+		return c.Msgpack(200, writs)
+	})
+
+	fmt.Println("Search Service Started")
+}
@@ -0,0 +1,211 @@
+package backend
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"time"
+
+	"github.com/Machiel/slugify"
+)
+
+// Series an ordered run of writs, like the parts of a tutorial
+type Series struct {
+	Key         string    `json:"_key,omitempty" msgpack:"_key,omitempty"`
+	Title       string    `json:"title" msgpack:"title"`
+	Slug        string    `json:"slug" msgpack:"slug"`
+	Description string    `json:"description,omitempty" msgpack:"description,omitempty"`
+	Writs       []string  `json:"writs" msgpack:"writs"`
+	Created     time.Time `json:"created" msgpack:"created"`
+	Modified    time.Time `json:"modified,omitempty" msgpack:"modified,omitempty"`
+}
+
+// SeriesByKeyOrSlug find a series going by either its key or its slug
+func SeriesByKeyOrSlug(id string) (Series, error) {
+	s, err := Stores.Series.ByKey(id)
+	if isNotFound(err) {
+		s, err = Stores.Series.BySlug(id)
+	}
+	if isNotFound(err) {
+		err = ErrNotFound
+	}
+	return s, err
+}
+
+// InitSeries check over a series and store it, creating it if it doesn't have a key yet
+func InitSeries(s *Series) error {
+	if len(s.Title) == 0 {
+		return SeriesIncompleteError
+	}
+	if len(s.Slug) == 0 {
+		s.Slug = slugify.Slugify(s.Title)
+	}
+	if s.Writs == nil {
+		s.Writs = []string{}
+	}
+
+	seen := map[string]bool{}
+	for _, key := range s.Writs {
+		if seen[key] {
+			return SeriesBadWritsError
+		}
+		seen[key] = true
+		if _, err := Stores.Writs.ByKey(key); err != nil {
+			return SeriesBadWritsError
+		}
+	}
+
+	var err error
+	if len(s.Key) == 0 {
+		s.Created = time.Now()
+		err = Stores.Series.Create(s)
+	} else {
+		var current Series
+		current, err = Stores.Series.ByKey(s.Key)
+		if err != nil {
+			return SeriesNotFoundError
+		}
+		s.Created = current.Created
+		s.Modified = time.Now()
+		err = Stores.Series.Replace(s)
+	}
+	if err == ErrConflict {
+		return SeriesConflictError
+	}
+	return err
+}
+
+// prepSeries look up the writs in the series a query is filtering by
+func (q *WritQuery) prepSeries() error {
+	if len(q.Series) == 0 {
+		return nil
+	}
+	s, err := SeriesByKeyOrSlug(q.Series)
+	if err != nil {
+		return err
+	}
+	q.seriesWrits = s.Writs
+	if q.seriesWrits == nil {
+		q.seriesWrits = []string{}
+	}
+	return nil
+}
+
+// seriesPosition where a writ sits in a series' order, or -1 when it isn't in it
+func seriesPosition(keys []string, key string) int {
+	for i, k := range keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// seriesPageData the series a writ is in, its table of contents
+// and the parts before and after it, as far as the user can see
+func seriesPageData(writ *Writ, user *User) obj {
+	containing, err := Stores.Series.Containing(writ.Key)
+	if err != nil || len(containing) == 0 {
+		return nil
+	}
+	// a writ in more than one series gets the navigation for the first of them
+	s := containing[0]
+
+	q := &WritQuery{Series: s.Key}
+	q.RestrictTo(user)
+	parts, err := q.Exec()
+	if err != nil {
+		return nil
+	}
+
+	toc := make([]obj, 0, len(parts))
+	data := obj{
+		"Title":       html.EscapeString(s.Title),
+		"Slug":        s.Slug,
+		"Description": html.EscapeString(s.Description),
+		"Total":       len(parts),
+	}
+	for i := range parts {
+		part := obj{
+			"Title":  html.EscapeString(parts[i].Title),
+			"URL":    parts[i].GetLink(),
+			"Number": strconv.Itoa(i + 1),
+		}
+		if parts[i].Key == writ.Key {
+			part["Current"] = true
+			data["Part"] = i + 1
+			if i > 0 {
+				data["Prev"] = toc[i-1]
+			}
+			if i+1 < len(parts) {
+				data["Next"] = obj{
+					"Title": html.EscapeString(parts[i+1].Title),
+					"URL":   parts[i+1].GetLink(),
+				}
+			}
+		}
+		toc = append(toc, part)
+	}
+	data["Parts"] = toc
+	return data
+}
+
+func initSeries() {
+	Server.GET("/series", AdminHandle(func(c ctx, user *User) error {
+		all, err := Stores.Series.All()
+		if err != nil && !isNotFound(err) {
+			return ServerDBError.Send(c)
+		}
+		if all == nil {
+			all = []Series{}
+		}
+		return c.Msgpack(200, all)
+	}))
+
+	Server.GET("/series/:key", AdminHandle(func(c ctx, user *User) error {
+		s, err := SeriesByKeyOrSlug(c.Param("key"))
+		if err != nil {
+			return SeriesNotFoundError.Send(c)
+		}
+
+		q := &WritQuery{Series: s.Key, EditorMode: true, IncludePrivate: true, IncludeMembersOnly: true}
+		q.Omissions = []string{"markdown"}
+		writs, err := q.Exec()
+		if err != nil && !isNotFound(err) {
+			return ServerDBError.Send(c)
+		}
+		return c.Msgpack(200, obj{"series": s, "writs": writs})
+	}))
+
+	Server.POST("/series", AdminHandle(func(c ctx, user *User) error {
+		var s Series
+		if err := c.Bind(&s); err != nil {
+			return BadRequestError.Send(c)
+		}
+
+		err := InitSeries(&s)
+		if err != nil {
+			if cr, ok := err.(*CodedResponse); ok {
+				return cr.Send(c)
+			}
+			if DevMode {
+				fmt.Println("POST /series - couldn't store a series: ", err)
+			}
+			return ServerDBError.Send(c)
+		}
+		return c.Msgpack(203, obj{"msg": "success!", "_key": s.Key, "slug": s.Slug})
+	}))
+
+	Server.DELETE("/series/:key", AdminHandle(func(c ctx, user *User) error {
+		err := Stores.Series.Remove(c.Param("key"))
+		if err != nil {
+			if isNotFound(err) {
+				return SeriesNotFoundError.Send(c)
+			}
+			return ServerDBError.Send(c)
+		}
+		return c.Msgpack(200, obj{"msg": "series deleted, its writs are still there"})
+	}))
+
+	fmt.Println("Series Service Started")
+}
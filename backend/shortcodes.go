@@ -0,0 +1,446 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// MaxIncludeSize the biggest file the include shortcode will put in a writ
+const MaxIncludeSize = 256 << 10
+
+// Shortcode a shortcode as written in a writ's markdown, either
+// {{< name key="value" >}} or {{< name key="value" >}}inner markdown{{< /name >}}
+type Shortcode struct {
+	Name  string
+	Args  map[string]string
+	Inner string
+	// Block whether it's on lines of its own rather than in with other text
+	Block bool
+}
+
+// Arg a shortcode's argument, or a default when it wasn't given
+func (sc *Shortcode) Arg(name, def string) string {
+	if value, ok := sc.Args[name]; ok {
+		return value
+	}
+	return def
+}
+
+// ShortcodeHandler turns a shortcode into html, when it fails
+// the shortcode's inner markdown is used in its place, if it has any
+type ShortcodeHandler func(doc *MarkdownDoc, sc *Shortcode) (string, error)
+
+var shortcodes = map[string]ShortcodeHandler{
+	"writ":    writCardShortcode,
+	"figure":  figureShortcode,
+	"details": detailsShortcode,
+	"callout": calloutShortcode,
+	"include": includeShortcode,
+	"video":   mediaPlayerShortcode("video"),
+	"audio":   mediaPlayerShortcode("audio"),
+	"youtube": youtubeShortcode,
+}
+
+// the shortcode hooks go in ahead of the rest here rather than in markdownHooks'
+// initializer, shortcodes render markdown of their own which would loop back to it
+func init() {
+	markdownHooks = append([]MarkdownHook{
+		{Name: "shortcodes", Stage: BeforeParse, Run: expandShortcodes},
+		{Name: "shortcodes", Stage: AfterRender, Run: insertShortcodes},
+	}, markdownHooks...)
+}
+
+// AddShortcode add a shortcode writs can use, or replace one of the built in ones
+func AddShortcode(name string, handler ShortcodeHandler) {
+	shortcodes[name] = handler
+}
+
+var (
+	shortcodePattern    = regexp.MustCompile(`\{\{<\s*(/?)([a-z][a-z0-9-]*)((?:\s+[a-z][a-z0-9-]*=(?:"[^"]*"|'[^']*'|[^\s"'>]+))*)\s*>\}\}`)
+	shortcodeArgPattern = regexp.MustCompile(`([a-z][a-z0-9-]*)=(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	youtubeIDPattern    = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+)
+
+var (
+	errShortcodeNoSuchWrit = errors.New("there's no public writ with that slug")
+	errShortcodeBadURL     = errors.New("the url has to be a path on this site or an http(s) link")
+	errShortcodeBadFile    = errors.New("the file has to be a regular file inside the assets")
+)
+
+// errShortcodeArg the error for a shortcode missing an argument it needs
+func errShortcodeArg(name string) error {
+	return errors.New("the " + name + " argument is missing")
+}
+
+// fencedCode where the fenced code blocks are in some markdown,
+// shortcodes in them are left as they are so writs can show how they're used
+func fencedCode(src []byte) [][2]int {
+	blocks := [][2]int{}
+	fence, start := "", 0
+	for pos := 0; pos < len(src); {
+		end := bytes.IndexByte(src[pos:], '\n')
+		if end == -1 {
+			end = len(src)
+		} else {
+			end += pos + 1
+		}
+		line := strings.TrimLeft(string(src[pos:end]), " ")
+		if len(fence) == 0 {
+			if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~") {
+				fence = line[:len(line)-len(strings.TrimLeft(line, line[:1]))]
+				start = pos
+			}
+		} else if strings.HasPrefix(line, fence) && len(strings.TrimSpace(strings.TrimLeft(line, fence[:1]))) == 0 {
+			blocks = append(blocks, [2]int{start, end})
+			fence = ""
+		}
+		pos = end
+	}
+	if len(fence) != 0 {
+		blocks = append(blocks, [2]int{start, len(src)})
+	}
+	return blocks
+}
+
+// onOwnLines whether src[start:end] has nothing but whitespace either side of it on its lines
+func onOwnLines(src []byte, start, end int) bool {
+	before := src[:start]
+	if i := bytes.LastIndexByte(before, '\n'); i != -1 {
+		before = before[i+1:]
+	}
+	after := src[end:]
+	if i := bytes.IndexByte(after, '\n'); i != -1 {
+		after = after[:i]
+	}
+	return len(bytes.TrimSpace(before)) == 0 && len(bytes.TrimSpace(after)) == 0
+}
+
+// shortcodeArgs the key="value" arguments of a shortcode
+func shortcodeArgs(raw string) map[string]string {
+	args := map[string]string{}
+	for _, match := range shortcodeArgPattern.FindAllStringSubmatch(raw, -1) {
+		args[match[1]] = match[2] + match[3] + match[4]
+	}
+	return args
+}
+
+// expandShortcodes run the shortcodes in a writ's markdown, what they make is swapped in
+// for a placeholder after rendering so blackfriday doesn't touch it
+func expandShortcodes(doc *MarkdownDoc) error {
+	src := doc.Source
+	matches := shortcodePattern.FindAllSubmatchIndex(src, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	fenced := fencedCode(src)
+	inFence := func(at int) bool {
+		for _, block := range fenced {
+			if at >= block[0] && at < block[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for i := 0; i < len(matches); i++ {
+		m := matches[i]
+		start, end := m[0], m[1]
+		name := string(src[m[4]:m[5]])
+		handler, ok := shortcodes[name]
+		if !ok || start < last || m[3] > m[2] || inFence(start) {
+			continue
+		}
+
+		sc := &Shortcode{Name: name, Args: shortcodeArgs(string(src[m[6]:m[7]]))}
+		// find the closing tag, if there is one, skipping over any of the same name nested inside
+		depth := 0
+		for j := i + 1; j < len(matches); j++ {
+			next := matches[j]
+			if inFence(next[0]) || string(src[next[4]:next[5]]) != name {
+				continue
+			}
+			if next[3] == next[2] {
+				depth++
+			} else if depth > 0 {
+				depth--
+			} else {
+				sc.Inner = strings.Trim(string(src[end:next[0]]), "\n")
+				end = next[1]
+				i = j
+				break
+			}
+		}
+		sc.Block = onOwnLines(src, start, end)
+
+		content, err := handler(doc, sc)
+		if err != nil {
+			if DevMode {
+				fmt.Println("markdown: the ", name, " shortcode in writ ", doc.Writ.Key, " failed - ", err)
+			}
+			content = ""
+			if len(sc.Inner) != 0 {
+				content = string(renderInner(doc, sc.Inner))
+			}
+		}
+
+		out.Write(src[last:start])
+		placeholder := doc.embed(content)
+		if sc.Block {
+			out.WriteString("\n\n" + placeholder + "\n\n")
+		} else {
+			out.WriteString(placeholder)
+		}
+		last = end
+	}
+	out.Write(src[last:])
+	doc.Source = out.Bytes()
+	return nil
+}
+
+// embed set some html aside to go into the rendered writ, the placeholder
+// it hands back goes in the markdown where the html should be
+func (doc *MarkdownDoc) embed(content string) string {
+	if len(doc.embedNonce) == 0 {
+		doc.embedNonce = RandStr(12)
+	}
+	doc.embeds = append(doc.embeds, content)
+	return "shortcode" + doc.embedNonce + "x" + strconv.Itoa(len(doc.embeds)-1) + "x"
+}
+
+// insertShortcodes put what the shortcodes made where their placeholders ended up
+func insertShortcodes(doc *MarkdownDoc) error {
+	for i, content := range doc.embeds {
+		placeholder := []byte("shortcode" + doc.embedNonce + "x" + strconv.Itoa(i) + "x")
+		doc.HTML = bytes.Replace(doc.HTML, append(append([]byte("<p>"), placeholder...), "</p>"...), []byte(content), -1)
+		doc.HTML = bytes.Replace(doc.HTML, placeholder, []byte(content), -1)
+	}
+	return nil
+}
+
+// renderInner render the markdown inside a shortcode, shortcodes in it included
+func renderInner(doc *MarkdownDoc, markdown string) []byte {
+	inner := RenderWritMarkdown(&Writ{Key: doc.Writ.Key, Markdown: markdown})
+	return bytes.TrimSpace(inner.HTML)
+}
+
+// safeURL check a url points somewhere on this site or to an http(s) link
+func safeURL(link string) (string, error) {
+	link = strings.TrimSpace(link)
+	lower := strings.ToLower(link)
+	if len(link) == 0 {
+		return "", errShortcodeBadURL
+	}
+	if strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://") {
+		return link, nil
+	}
+	if strings.HasPrefix(link, "/") && !strings.HasPrefix(link, "//") {
+		return link, nil
+	}
+	return "", errShortcodeBadURL
+}
+
+// writCardShortcode {{< writ slug="some-writ" >}} a card linking to another public writ
+func writCardShortcode(doc *MarkdownDoc, sc *Shortcode) (string, error) {
+	slug := sc.Arg("slug", "")
+	if len(slug) == 0 {
+		return "", errShortcodeArg("slug")
+	}
+	q := &WritQuery{Slug: slug, Omissions: []string{"markdown", "content", "injection", "likedby", "viewedby"}}
+	q.RestrictTo(nil)
+	writ, err := q.ExecOne()
+	if err != nil {
+		if isNotFound(err) {
+			return "", errShortcodeNoSuchWrit
+		}
+		return "", err
+	}
+
+	card := `<a class="writ-card" href="` + html.EscapeString(writ.GetLink()) + `">` +
+		`<span class="writ-card-title">` + html.EscapeString(writ.Title) + `</span>`
+	if len(writ.Description) != 0 {
+		card += `<span class="writ-card-description">` + html.EscapeString(writ.Description) + `</span>`
+	}
+	return card + `</a>`, nil
+}
+
+// figureShortcode {{< figure src="/media/..." alt="..." caption="..." >}}, the caption can
+// also be given as inner markdown
+func figureShortcode(doc *MarkdownDoc, sc *Shortcode) (string, error) {
+	src, err := safeURL(sc.Arg("src", ""))
+	if err != nil {
+		return "", err
+	}
+	figure := `<figure class="figure"><img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(sc.Arg("alt", "")) + `">`
+	if len(sc.Inner) != 0 {
+		figure += `<figcaption>` + string(renderInner(doc, sc.Inner)) + `</figcaption>`
+	} else if caption := sc.Arg("caption", ""); len(caption) != 0 {
+		figure += `<figcaption>` + html.EscapeString(caption) + `</figcaption>`
+	}
+	return figure + `</figure>`, nil
+}
+
+// detailsShortcode {{< details summary="..." open="true" >}}markdown{{< /details >}}
+func detailsShortcode(doc *MarkdownDoc, sc *Shortcode) (string, error) {
+	open := ""
+	if sc.Arg("open", "") == "true" {
+		open = " open"
+	}
+	return `<details` + open + `><summary>` + html.EscapeString(sc.Arg("summary", "Details")) + `</summary>` +
+		string(renderInner(doc, sc.Inner)) + `</details>`, nil
+}
+
+var calloutKinds = []string{"note", "tip", "info", "warning", "danger"}
+
+// calloutShortcode {{< callout kind="warning" title="..." >}}markdown{{< /callout >}}
+func calloutShortcode(doc *MarkdownDoc, sc *Shortcode) (string, error) {
+	kind := strings.ToLower(sc.Arg("kind", "note"))
+	if !stringsContain(calloutKinds, kind) {
+		kind = "note"
+	}
+	callout := `<aside class="callout callout-` + kind + `">`
+	if title := sc.Arg("title", ""); len(title) != 0 {
+		callout += `<p class="callout-title">` + html.EscapeString(title) + `</p>`
+	}
+	return callout + string(renderInner(doc, sc.Inner)) + `</aside>`, nil
+}
+
+// resolveAsset where a file in the assets dir really is once every symlink's followed,
+// it fails when that's somewhere outside the assets
+func resolveAsset(location string) (string, error) {
+	assets, err := filepath.EvalSymlinks(Conf.Assets)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(location)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(assets, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errShortcodeBadFile
+	}
+	return real, nil
+}
+
+// includeShortcode {{< include file="code/example.go" lang="go" lines="10-20" >}} code from
+// a file in the assets, highlighted like any other code block
+func includeShortcode(doc *MarkdownDoc, sc *Shortcode) (string, error) {
+	file := sc.Arg("file", "")
+	if len(file) == 0 {
+		return "", errShortcodeArg("file")
+	}
+	// cleaning it as an absolute path first keeps it from climbing out of the assets
+	location := filepath.Join(Conf.Assets, filepath.FromSlash(path.Clean("/"+file)))
+	// and a symlink mustn't lead anywhere else either
+	real, err := resolveAsset(location)
+	if err != nil {
+		return "", errShortcodeBadFile
+	}
+	info, err := os.Stat(real)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > MaxIncludeSize {
+		return "", errShortcodeBadFile
+	}
+	data, err := ioutil.ReadFile(real)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.Replace(string(data), "\r\n", "\n", -1)
+	if lines := sc.Arg("lines", ""); len(lines) != 0 {
+		all := strings.Split(code, "\n")
+		from, to := 1, len(all)
+		bounds := strings.SplitN(lines, "-", 2)
+		if n, err := strconv.Atoi(strings.TrimSpace(bounds[0])); err == nil {
+			from, to = n, n
+		}
+		if len(bounds) == 2 {
+			to = len(all)
+			if n, err := strconv.Atoi(strings.TrimSpace(bounds[1])); err == nil {
+				to = n
+			}
+		}
+		if from < 1 {
+			from = 1
+		}
+		if to > len(all) {
+			to = len(all)
+		}
+		if from > to {
+			return "", errors.New("the lines " + lines + " aren't in " + file)
+		}
+		code = strings.Join(all[from-1:to], "\n")
+	}
+	if !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+
+	lang := sc.Arg("lang", strings.TrimPrefix(filepath.Ext(location), "."))
+	node := blackfriday.NewNode(blackfriday.CodeBlock)
+	node.IsFenced = true
+	node.Info = []byte(lang)
+	node.Literal = []byte(code)
+
+	var out bytes.Buffer
+	if markdownEnabled("highlight") {
+		if _, ok := highlightCodeBlock(doc, &out, node, true); ok {
+			return out.String(), nil
+		}
+	}
+	class := ""
+	if len(lang) != 0 {
+		class = ` class="language-` + html.EscapeString(lang) + `"`
+	}
+	return `<pre><code` + class + `>` + html.EscapeString(code) + `</code></pre>`, nil
+}
+
+// mediaPlayerShortcode {{< video src="/media/..." >}} and {{< audio src="..." >}}, inner
+// markdown is shown by browsers that can't play it
+func mediaPlayerShortcode(tag string) ShortcodeHandler {
+	return func(doc *MarkdownDoc, sc *Shortcode) (string, error) {
+		src, err := safeURL(sc.Arg("src", ""))
+		if err != nil {
+			return "", err
+		}
+		player := `<` + tag + ` class="` + tag + `-player" src="` + html.EscapeString(src) + `" controls preload="metadata"`
+		if tag == "video" {
+			if poster, err := safeURL(sc.Arg("poster", "")); err == nil {
+				player += ` poster="` + html.EscapeString(poster) + `"`
+			}
+			for _, dimension := range []string{"width", "height"} {
+				if n, err := strconv.Atoi(sc.Arg(dimension, "")); err == nil && n > 0 {
+					player += ` ` + dimension + `="` + strconv.Itoa(n) + `"`
+				}
+			}
+		}
+		fallback := `<a href="` + html.EscapeString(src) + `">` + html.EscapeString(path.Base(src)) + `</a>`
+		if len(sc.Inner) != 0 {
+			fallback = string(renderInner(doc, sc.Inner))
+		}
+		return player + `>` + fallback + `</` + tag + `>`, nil
+	}
+}
+
+// youtubeShortcode {{< youtube id="dQw4w9WgXcQ" >}} a youtube video, embedded without cookies
+func youtubeShortcode(doc *MarkdownDoc, sc *Shortcode) (string, error) {
+	id := sc.Arg("id", "")
+	if !youtubeIDPattern.MatchString(id) {
+		return "", errors.New("the id has to be a youtube video id")
+	}
+	title := html.EscapeString(sc.Arg("title", "YouTube video"))
+	return `<div class="video-embed"><iframe src="https://www.youtube-nocookie.com/embed/` + id + `" title="` + title +
+		`" loading="lazy" allow="encrypted-media; picture-in-picture" allowfullscreen></iframe></div>`, nil
+}
@@ -0,0 +1,148 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShortcodesInFencedCode(t *testing.T) {
+	Conf = &Config{}
+	out := string(renderTestMarkdown("```\n{{< callout >}}shown as is{{< /callout >}}\n```\n").HTML)
+	if strings.Contains(out, "<aside") {
+		t.Errorf("a shortcode in a fenced block was expanded: %s", out)
+	}
+	if !strings.Contains(out, "{{&lt; callout &gt;}}shown as is{{&lt; /callout &gt;}}") {
+		t.Errorf("a shortcode in a fenced block didn't come out as written: %s", out)
+	}
+}
+
+func TestNestedShortcodes(t *testing.T) {
+	Conf = &Config{}
+	out := string(renderTestMarkdown(`{{< details summary="outer" >}}
+before
+
+{{< details summary="inner" >}}
+*deep*
+{{< /details >}}
+
+after
+{{< /details >}}
+`).HTML)
+
+	outer, inner := strings.Index(out, "<summary>outer</summary>"), strings.Index(out, "<summary>inner</summary>")
+	if outer == -1 || inner == -1 || inner < outer {
+		t.Fatalf("the details didn't nest: %s", out)
+	}
+	if strings.Count(out, "</details>") != 2 || !strings.HasSuffix(strings.TrimSpace(out), "</details>") {
+		t.Errorf("the details weren't closed where they should be: %s", out)
+	}
+	if !strings.Contains(out, "<em>deep</em>") || strings.Contains(out, "{{<") {
+		t.Errorf("the inner markdown wasn't rendered: %s", out)
+	}
+}
+
+func TestFailingShortcodeFallsBack(t *testing.T) {
+	Conf = &Config{}
+	out := string(renderTestMarkdown(`{{< figure src="javascript:alert(1)" >}}a **caption**{{< /figure >}}`).HTML)
+	if strings.Contains(out, "<figure") || strings.Contains(out, "javascript:") {
+		t.Errorf("a figure with a bad src was still made: %s", out)
+	}
+	if !strings.Contains(out, "a <strong>caption</strong>") {
+		t.Errorf("the failed shortcode's inner markdown wasn't used in its place: %s", out)
+	}
+
+	out = string(renderTestMarkdown(`text {{< youtube id="nope" >}} more`).HTML)
+	if !strings.Contains(out, "<p>text  more</p>") {
+		t.Errorf("a failed shortcode without inner markdown should leave nothing behind: %s", out)
+	}
+}
+
+func TestIncludeStaysInAssets(t *testing.T) {
+	dir := t.TempDir()
+	Conf = &Config{Assets: filepath.Join(dir, "assets")}
+	for name, content := range map[string]string{
+		"assets/code/example.go": "package example\n",
+		"secret.txt":             "don't show this\n",
+	} {
+		location := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(location), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(location, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"assets/code/inside.go":  "example.go",
+		"assets/code/escape.txt": filepath.Join(dir, "secret.txt"),
+		"assets/code/up":         "../..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Skip("can't make symlinks here: ", err)
+		}
+	}
+
+	doc := &MarkdownDoc{Writ: &Writ{}}
+	cases := []struct {
+		file string
+		ok   bool
+	}{
+		{"code/example.go", true},
+		{"/code/example.go", true},
+		{"code/inside.go", true},
+		{"../secret.txt", false},
+		{"code/../../secret.txt", false},
+		{"code/escape.txt", false},
+		{"code/up/secret.txt", false},
+		{"code", false},
+		{"code/missing.go", false},
+	}
+	for _, tc := range cases {
+		out, err := includeShortcode(doc, &Shortcode{Name: "include", Args: map[string]string{"file": tc.file}})
+		if tc.ok {
+			if err != nil || !strings.Contains(out, "package") {
+				t.Errorf("including %s: got %q, %v", tc.file, out, err)
+			}
+		} else if err != errShortcodeBadFile || strings.Contains(out, "don") {
+			t.Errorf("including %s should fail with errShortcodeBadFile: got %q, %v", tc.file, out, err)
+		}
+	}
+}
+
+func TestSanitizingKeepsShortcodes(t *testing.T) {
+	setupTestStore(t)
+	w := &Writ{Author: "author", Markdown: `{{< callout kind="warning" title="Careful" >}}mind the **gap**{{< /callout >}}
+
+{{< details summary="More" open="true" >}}
+- [x] done
+{{< /details >}}
+
+{{< youtube id="dQw4w9WgXcQ" >}}
+
+{{< video src="/media/clip.mp4" width="640" >}}
+
+<script>alert(1)</script>
+`}
+	w.RenderContent()
+
+	for _, want := range []string{
+		`<aside class="callout callout-warning">`,
+		`<p class="callout-title">Careful</p>`,
+		`<strong>gap</strong>`,
+		`<details open=""><summary>More</summary>`,
+		`<input type="checkbox" class="task" disabled="" checked="">`,
+		`<iframe src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ"`,
+		`<video class="video-player" src="/media/clip.mp4" controls="" preload="metadata" width="640">`,
+	} {
+		if !strings.Contains(w.Content, want) {
+			t.Errorf("sanitizing dropped %s from %s", want, w.Content)
+		}
+	}
+	if strings.Contains(w.Content, "<script") {
+		t.Errorf("a script got through: %s", w.Content)
+	}
+}
@@ -0,0 +1,220 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tr "github.com/SaulDoesCode/transplacer"
+)
+
+// SitemapMaxURLs the most URLs a single sitemap may list, past that it's split up behind a sitemap index
+const SitemapMaxURLs = 50000
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// builtSitemap the sitemap(s) as they'll be served, Index is only set when there's more than one part
+type builtSitemap struct {
+	Index    []byte
+	Parts    [][]byte
+	ETag     string
+	Modified time.Time
+}
+
+var (
+	sitemap     *builtSitemap
+	sitemapLock sync.Mutex
+)
+
+// clearSitemapCache drop the cached sitemap, it'll be rebuilt when next requested
+func clearSitemapCache() {
+	sitemapLock.Lock()
+	sitemap = nil
+	sitemapLock.Unlock()
+}
+
+// sitemapEntries every writ that anonymous viewers (and so crawlers) may read
+func sitemapEntries() ([]Writ, error) {
+	q := &WritQuery{Omissions: []string{"markdown", "content", "description", "injection"}}
+	q.RestrictTo(nil)
+	return Stores.Writs.Query(q)
+}
+
+func buildSitemap() (*builtSitemap, error) {
+	entries, err := sitemapEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	root := siteURL()
+	urls := make([]sitemapURL, 0, len(entries)+1)
+	urls = append(urls, sitemapURL{Loc: root + "/"})
+
+	var modified time.Time
+	tagsModified := map[string]time.Time{}
+	for _, entry := range entries {
+		writ := entry
+		lastmod := writ.LastModified()
+		if lastmod.After(modified) {
+			modified = lastmod
+		}
+		urls = append(urls, sitemapURL{
+			Loc:     writ.GetLink(),
+			LastMod: lastmod.Format(time.RFC3339),
+		})
+		for _, tag := range entry.Tags {
+			if lastmod.After(tagsModified[tag]) {
+				tagsModified[tag] = lastmod
+			}
+		}
+	}
+
+	tags := make([]string, 0, len(tagsModified))
+	for tag := range tagsModified {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		urls = append(urls, sitemapURL{
+			Loc:     root + "/tag/" + url.PathEscape(tag),
+			LastMod: tagsModified[tag].Format(time.RFC3339),
+		})
+	}
+
+	if modified.IsZero() {
+		modified = StartupDate
+	}
+	built := &builtSitemap{Modified: modified.UTC().Truncate(time.Second)}
+
+	for start := 0; start < len(urls); start += SitemapMaxURLs {
+		end := start + SitemapMaxURLs
+		if end > len(urls) {
+			end = len(urls)
+		}
+		out, err := xml.Marshal(sitemapURLSet{URLs: urls[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		built.Parts = append(built.Parts, append([]byte(xml.Header), out...))
+	}
+
+	if len(built.Parts) > 1 {
+		index := sitemapIndex{}
+		for i := range built.Parts {
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{
+				Loc:     root + "/sitemap/" + strconv.Itoa(i+1) + ".xml",
+				LastMod: built.Modified.Format(time.RFC3339),
+			})
+		}
+		out, err := xml.Marshal(index)
+		if err != nil {
+			return nil, err
+		}
+		built.Index = append([]byte(xml.Header), out...)
+		built.ETag = `"` + MD5Hash(built.Index) + `"`
+	} else {
+		built.ETag = `"` + MD5Hash(built.Parts[0]) + `"`
+	}
+
+	return built, nil
+}
+
+// currentSitemap get the cached sitemap, building it first if need be
+func currentSitemap() (*builtSitemap, error) {
+	sitemapLock.Lock()
+	defer sitemapLock.Unlock()
+	if sitemap != nil {
+		return sitemap, nil
+	}
+
+	built, err := buildSitemap()
+	if err != nil {
+		return nil, err
+	}
+	sitemap = built
+	return sitemap, nil
+}
+
+// robotsTxt the assets' robots.txt with the sitemap tacked onto the end
+func robotsTxt() []byte {
+	var buf bytes.Buffer
+	if len(Conf.Assets) != 0 {
+		base, err := ioutil.ReadFile(tr.PrepPath(Conf.Assets, "/robots.txt"))
+		if err == nil {
+			for _, line := range strings.Split(string(base), "\n") {
+				// any sitemap lines in the static file are replaced by the generated one
+				if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(line)), "sitemap:") {
+					buf.WriteString(strings.TrimRight(line, "\r") + "\n")
+				}
+			}
+		}
+	}
+	if buf.Len() == 0 {
+		buf.WriteString("User-agent: *\n")
+	}
+	buf.WriteString("\nSitemap: " + siteURL() + "/sitemap.xml\n")
+	return buf.Bytes()
+}
+
+func initSitemap() {
+	Server.GET("/sitemap.xml", func(c ctx) error {
+		sm, err := currentSitemap()
+		if err != nil {
+			if DevMode {
+				fmt.Println("sitemap: couldn't build it - ", err)
+			}
+			return ServerDBError.Send(c)
+		}
+
+		body := sm.Index
+		if body == nil {
+			body = sm.Parts[0]
+		}
+		return serveCached(c, body, "application/xml; charset=utf-8", sm.ETag, sm.Modified)
+	})
+
+	Server.GET("/sitemap/:part", func(c ctx) error {
+		part, err := strconv.Atoi(strings.TrimSuffix(c.Param("part"), ".xml"))
+		if err != nil {
+			return Err404NotFound
+		}
+
+		sm, err := currentSitemap()
+		if err != nil {
+			return ServerDBError.Send(c)
+		}
+		if part < 1 || part > len(sm.Parts) {
+			return Err404NotFound
+		}
+
+		body := sm.Parts[part-1]
+		return serveCached(c, body, "application/xml; charset=utf-8", `"`+MD5Hash(body)+`"`, sm.Modified)
+	})
+
+	Server.GET("/robots.txt", func(c ctx) error {
+		c.Response().Header().Set("Cache-Control", "public, max-age=3600")
+		return c.Blob(200, "text/plain; charset=utf-8", robotsTxt())
+	})
+
+	fmt.Println("Sitemap Service Started")
+}
@@ -0,0 +1,991 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/arangodb/go-driver"
+)
+
+// arangoUserStore keeps users in the users collection
+type arangoUserStore struct{}
+
+func (arangoUserStore) ByKey(key string) (User, error) {
+	var user User
+	_, err := Users.ReadDocument(context.Background(), key, &user)
+	return user, err
+}
+
+func (arangoUserStore) ByUsername(username string) (User, error) {
+	var user User
+	err := QueryOne(FindUSERByUsername, obj{"username": username}, &user)
+	return user, err
+}
+
+func (arangoUserStore) ByEmail(email string) (User, error) {
+	var user User
+	err := QueryOne(FindUSERByEmail, obj{"email": email}, &user)
+	return user, err
+}
+
+func (arangoUserStore) ByDetails(email, username string) (User, error) {
+	var user User
+	err := QueryOne(FindUserByDetails, obj{
+		"email":    email,
+		"username": username,
+	}, &user)
+	return user, err
+}
+
+func (arangoUserStore) Create(user *User) error {
+	err := QueryOne(CreateUser, obj{
+		"email":    user.Email,
+		"emailmd5": user.EmailMD5,
+		"username": user.Username,
+		"roles":    user.Roles,
+		"created":  user.Created,
+	}, user)
+	if driver.IsConflict(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (arangoUserStore) Update(key string, changes obj) (User, error) {
+	var user User
+	err := QueryOne(
+		`FOR u in users FILTER u._key == @key UPDATE u WITH @changes IN users OPTIONS {keepNull: false, waitForSync: true} RETURN NEW`,
+		obj{"key": key, "changes": changes},
+		&user,
+	)
+	return user, err
+}
+
+func (arangoUserStore) Append(key, field string, values ...interface{}) (User, error) {
+	var user User
+	err := QueryOne(
+		`FOR u in users FILTER u._key == @key UPDATE u WITH {[@field]: APPEND(u[@field] || [], @values, true)} IN users OPTIONS {waitForSync: true} RETURN NEW`,
+		obj{"key": key, "field": field, "values": values},
+		&user,
+	)
+	return user, err
+}
+
+func (arangoUserStore) RemoveValues(key, field string, values ...interface{}) (User, error) {
+	var user User
+	err := QueryOne(
+		`FOR u in users FILTER u._key == @key UPDATE u WITH {[@field]: REMOVE_VALUES(u[@field] || [], @values)} IN users OPTIONS {waitForSync: true} RETURN NEW`,
+		obj{"key": key, "field": field, "values": values},
+		&user,
+	)
+	return user, err
+}
+
+func (arangoUserStore) Subscribers() ([]User, error) {
+	query := `FOR u IN users FILTER u.subscriber == true RETURN u`
+	ctx := driver.WithQueryCount(context.Background())
+	cursor, err := DB.Query(ctx, query, obj{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	users := []User{}
+	for {
+		var doc User
+		_, err = cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return users, err
+		}
+		users = append(users, doc)
+	}
+	return users, nil
+}
+
+// arangoWritStore keeps writs in the writs collection
+type arangoWritStore struct{}
+
+func (arangoWritStore) Query(q *WritQuery) ([]Writ, error) {
+	writs := []Writ{}
+
+	if q.Vars == nil {
+		q.Vars = obj{}
+	}
+
+	query := "FOR writ IN writs "
+	if len(q.Search) > 0 {
+		query = "FOR writ IN " + WritSearchView + " " + searchClause(q.Search, q.Vars)
+	}
+
+	filter := ""
+	firstfilter := true
+
+	if q.PrivateOnly {
+		if !firstfilter {
+			filter += "&& "
+		} else {
+			firstfilter = false
+		}
+		filter += `writ.public == false `
+	} else if !q.IncludePrivate {
+		if !firstfilter {
+			filter += "&& "
+		} else {
+			firstfilter = false
+		}
+		filter += `writ.public == true `
+	}
+
+	if q.MembersOnly {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		filter += `writ.membersonly == true `
+	} else if !q.IncludeMembersOnly {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		filter += `writ.membersonly != true `
+	}
+
+	if q.restricted {
+		if visibility := visibilityFilter(q.viewer, q.Vars); len(visibility) > 0 {
+			if !firstfilter {
+				filter += "&& "
+			}
+			firstfilter = false
+			filter += visibility
+		}
+	}
+
+	startzero := q.Between.Start.IsZero()
+	endzero := q.Between.End.IsZero()
+	if !startzero || !endzero {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		if !startzero && !endzero {
+			q.Vars["@betweenStart"] = q.Between.Start
+			q.Vars["@betweenEnd"] = q.Between.End
+			filter += "writ.created > @betweenStart && writ.created < @betweenEnd "
+		} else if !startzero {
+			q.Vars["@betweenStart"] = q.Between.Start
+			filter += "writ.created > @betweenStart "
+		} else if !endzero {
+			q.Vars["@betweenEnd"] = q.Between.Start
+			filter += "writ.created < @betweenEnd "
+		}
+	}
+
+	if len(q.Author) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["author"] = q.Author
+		filter += `writ.author == @author `
+	}
+
+	if len(q.State) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["state"] = q.State
+		// writs from before there were states are either published or drafts
+		filter += `(HAS(writ, "state") ? writ.state : (writ.public ? "published" : "draft")) == @state `
+	}
+
+	if len(q.ViewedBy) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["viewedby"] = q.ViewedBy
+		filter += `@viewedby IN writ.viewedby `
+	}
+
+	if len(q.LikedBy) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["likedby"] = q.LikedBy
+		filter += `@likedby IN writ.likedby `
+	}
+
+	if len(q.Roles) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["roles"] = q.Roles
+		filter += `@roles ALL IN writ.roles `
+	}
+
+	if len(q.Tags) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["tags"] = q.Tags
+		filter += `@tags ALL IN writ.tags `
+	}
+
+	if len(q.Series) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["serieswrits"] = q.seriesWrits
+		filter += `writ._key IN @serieswrits `
+	}
+
+	if q.relatedTo != nil {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		tags, likers := q.relatedTo.Tags, q.relatedTo.LikedBy
+		if tags == nil {
+			tags = []string{}
+		}
+		if likers == nil {
+			likers = []string{}
+		}
+		q.Vars["relatedkey"] = q.relatedTo.Key
+		q.Vars["relatedtags"] = tags
+		q.Vars["relatedlikers"] = likers
+		filter += `writ._key != @relatedkey && (writ.tags ANY IN @relatedtags || writ.likedby ANY IN @relatedlikers) `
+	}
+
+	if !firstfilter {
+		query += "FILTER " + filter
+	}
+
+	if len(q.Search) > 0 {
+		query += "SORT BM25(writ) DESC "
+	} else if len(q.Series) > 0 && !q.DontSort {
+		// in the series' own order
+		query += "SORT POSITION(@serieswrits, writ._key, true) "
+	} else if !q.DontSort {
+		query += "SORT writ.created DESC "
+	}
+
+	if len(q.Limit) > 0 {
+		q.Vars["pagenum"] = q.Limit[0]
+		query += `LIMIT @pagenum`
+		if len(q.Limit) == 2 {
+			q.Vars["pagesize"] = q.Limit[1]
+			query += `, @pagesize `
+		}
+	}
+
+	query += " RETURN "
+
+	if len(q.Omissions) > 0 {
+		q.Vars["omissions"] = q.Omissions
+		query += "UNSET(writ, @omissions)"
+	} else {
+		query += "writ"
+	}
+
+	if DevMode {
+		fmt.Println("\n You're trying this query now: \n", query, "\n\t")
+	}
+
+	ctx := driver.WithQueryCount(context.Background())
+	cursor, err := DB.Query(ctx, query, q.Vars)
+	if err == nil {
+		defer cursor.Close()
+		for {
+			var writ Writ
+			_, err := cursor.ReadDocument(ctx, &writ)
+			if driver.IsNoMoreDocuments(err) {
+				break
+			} else if err != nil {
+				if DevMode {
+					fmt.Println("DB Multiple Query - something strange happened: ", err)
+				}
+				panic(err)
+			}
+			writs = append(writs, writ)
+		}
+	} else if driver.IsNoMoreDocuments(err) {
+		fmt.Println(`No more docs? Awww :( - `, err)
+	} else if DevMode {
+		fmt.Println("\n... And, it would seem that it has failed: \n", err, "\n\t")
+	}
+	return writs, err
+}
+
+func (arangoWritStore) QueryOne(q *WritQuery) (Writ, error) {
+	var writ Writ
+
+	if q.Vars == nil {
+		q.Vars = obj{}
+	}
+
+	query := "FOR writ IN writs "
+
+	filter := ""
+	firstfilter := true
+
+	if q.PrivateOnly {
+		if !firstfilter {
+			filter += "&& "
+		} else {
+			firstfilter = false
+		}
+		filter += `writ.public == false `
+	} else if !q.IncludePrivate {
+		if !firstfilter {
+			filter += "&& "
+		} else {
+			firstfilter = false
+		}
+		filter += `writ.public == true `
+	}
+
+	if q.MembersOnly {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		filter += `writ.membersonly == true `
+	}
+
+	if q.restricted {
+		if visibility := visibilityFilter(q.viewer, q.Vars); len(visibility) > 0 {
+			if !firstfilter {
+				filter += "&& "
+			}
+			firstfilter = false
+			filter += visibility
+		}
+	}
+
+	if !q.Created.IsZero() {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["@created"] = q.Created
+		filter += "writ.created == @created "
+	}
+
+	if len(q.Key) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["key"] = q.Key
+		filter += `writ._key == @key `
+	}
+
+	if len(q.Slug) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["slug"] = q.Slug
+		filter += `writ.slug == @slug `
+	}
+
+	if len(q.Title) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["title"] = q.Title
+		filter += `writ.title == @title `
+	}
+
+	if len(q.Author) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["author"] = q.Author
+		filter += `writ.author == @author `
+	}
+
+	if len(q.State) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["state"] = q.State
+		// writs from before there were states are either published or drafts
+		filter += `(HAS(writ, "state") ? writ.state : (writ.public ? "published" : "draft")) == @state `
+	}
+
+	if len(q.ViewedBy) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["viewedby"] = q.ViewedBy
+		filter += `@viewedby IN writ.viewedby `
+	}
+
+	if len(q.LikedBy) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["likedby"] = q.LikedBy
+		filter += `@likedby IN writ.likedby `
+	}
+
+	if len(q.Roles) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["roles"] = q.Roles
+		filter += `@roles ALL IN writ.roles `
+	}
+
+	if len(q.Tags) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["tags"] = q.Tags
+		filter += `@tags ALL IN writ.tags `
+	}
+
+	if len(q.Series) > 0 {
+		if !firstfilter {
+			filter += "&& "
+		}
+		firstfilter = false
+		q.Vars["serieswrits"] = q.seriesWrits
+		filter += `writ._key IN @serieswrits `
+	}
+
+	if !firstfilter {
+		query += "FILTER " + filter
+	}
+
+	if q.UpdateViews {
+		query += "UPDATE writ WITH {"
+		if len(q.Viewer) > 0 {
+			q.Vars["viewer"] = q.Viewer
+			query += "viewedby: PUSH(writ.viewedby, @viewer, true)"
+		} else {
+			query += "views: writ.views + 1"
+		}
+		query += "} IN writs "
+	}
+
+	query += "RETURN "
+
+	if len(q.Omissions) > 0 {
+		q.Vars["omissions"] = q.Omissions
+		query += "UNSET(writ, @omissions)"
+	} else {
+		query += "writ"
+	}
+
+	if DevMode {
+		fmt.Println("\n You're trying this query now: \n", query, "\n\t")
+	}
+
+	err := QueryOne(query, q.Vars, &writ)
+
+	if DevMode && err != nil {
+		fmt.Println("\n... And, it would seem that it has failed: \n", err, "\n\t")
+	}
+
+	return writ, err
+}
+
+func (arangoWritStore) ByKey(key string) (Writ, error) {
+	var writ Writ
+	_, err := Writs.ReadDocument(context.Background(), key, &writ)
+	return writ, err
+}
+
+func (arangoWritStore) Create(w *Writ) error {
+	ctx := driver.WithWaitForSync(context.Background(), true)
+	w.EditRev = RandStr(11)
+	meta, err := Writs.CreateDocument(ctx, w)
+	if driver.IsConflict(err) {
+		return ErrConflict
+	}
+	if err == nil {
+		w.Key = meta.Key
+		w.Rev = meta.Rev
+	}
+	return err
+}
+
+func (arangoWritStore) Update(key, editrev string, changes obj) (string, error) {
+	delete(changes, "_rev")
+	delete(changes, "editrev")
+	newrev := RandStr(11)
+	var updated string
+	err := QueryOne(
+		`FOR w IN writs FILTER w._key == @key && (@editrev == "" || w.editrev == @editrev)
+		UPDATE w WITH MERGE(@changes, {editrev: @newrev}) IN writs OPTIONS {mergeObjects: true, waitForSync: true}
+		RETURN NEW.editrev`,
+		obj{"key": key, "editrev": editrev, "changes": changes, "newrev": newrev},
+		&updated,
+	)
+	if driver.IsNoMoreDocuments(err) {
+		// either it's gone or someone else's edit got in first
+		exists, eerr := Writs.DocumentExists(context.Background(), key)
+		if eerr != nil {
+			return "", eerr
+		} else if exists {
+			return "", ErrStaleRevision
+		}
+		return "", ErrNotFound
+	}
+	return updated, err
+}
+
+func (arangoWritStore) ToggleLike(slug, userKey string) error {
+	ctx := driver.WithKeepNull(driver.WithWaitForSync(driver.WithQueryCount(context.Background())), false)
+	_, err := DB.Query(
+		ctx,
+		`FOR w IN writs FILTER w.slug == @slug UPDATE w WITH {
+			 likedby: @user IN w.likedby ? REMOVE_VALUE(w.likedby, @user) : PUSH(w.likedby, @user)
+		 } IN writs`,
+		obj{
+			"slug": slug,
+			"user": userKey,
+		},
+	)
+	return err
+}
+
+func (arangoWritStore) ClaimNotification(key string) (bool, error) {
+	var claimed string
+	err := QueryOne(
+		`FOR w IN writs FILTER w._key == @key && w.notified != true
+		UPDATE w WITH {notified: true} IN writs OPTIONS {waitForSync: true}
+		RETURN NEW._key`,
+		obj{"key": key},
+		&claimed,
+	)
+	if driver.IsNoMoreDocuments(err) {
+		return false, nil
+	}
+	return err == nil && claimed == key, err
+}
+
+func (arangoWritStore) Remove(key string) error {
+	_, err := Writs.RemoveDocument(driver.WithWaitForSync(context.Background(), true), key)
+	return err
+}
+
+// arangoLogStore keeps request logs in the logs collection
+type arangoLogStore struct{}
+
+func (arangoLogStore) Add(entries []LogEntry) error {
+	_, errs, err := Logs.CreateDocuments(nil, entries)
+	if err != nil {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// arangoRateLimitStore keeps ratelimits in the ratelimits collection
+type arangoRateLimitStore struct{}
+
+func (arangoRateLimitStore) Hit(key string, start int64) (ratelimit, error) {
+	var limit ratelimit
+	err := QueryOne(
+		`UPSERT {_key: @key}
+		INSERT {_key: @key, start: @start, count: 0}
+		UPDATE {count: OLD.count + 1} IN ratelimits OPTIONS {waitForSync: true}
+		RETURN NEW`,
+		obj{"start": start, "key": key},
+		&limit,
+	)
+	return limit, err
+}
+
+func (arangoRateLimitStore) Penalize(key string, start int64) error {
+	_, err := DB.Query(
+		driver.WithWaitForSync(context.Background()),
+		`FOR l IN ratelimits FILTER l._key == @key
+		 UPDATE l WITH {count: l.count + 1, start: @start} IN ratelimits`,
+		obj{"start": start, "key": key},
+	)
+	return err
+}
+
+func (arangoRateLimitStore) Reset(key string) error {
+	_, err := RateLimits.RemoveDocument(driver.WithWaitForSync(context.Background()), key)
+	return err
+}
+
+// arangoRedirectStore keeps old paths in the redirects collection, keyed by redirectKey
+type arangoRedirectStore struct{}
+
+func (arangoRedirectStore) Add(path, writKey string) error {
+	path = redirectPath(path)
+	_, err := DB.Query(
+		driver.WithWaitForSync(context.Background()),
+		`UPSERT {_key: @key}
+		INSERT {_key: @key, path: @path, writ: @writ, created: @now}
+		UPDATE {writ: @writ} IN redirects`,
+		obj{"key": redirectKey(path), "path": path, "writ": writKey, "now": time.Now()},
+	)
+	return err
+}
+
+func (arangoRedirectStore) Lookup(path string) (string, error) {
+	var r redirect
+	_, err := Redirects.ReadDocument(context.Background(), redirectKey(redirectPath(path)), &r)
+	return r.Writ, err
+}
+
+func (arangoRedirectStore) RemoveWrit(writKey string) error {
+	_, err := DB.Query(
+		driver.WithWaitForSync(context.Background()),
+		`FOR r IN redirects FILTER r.writ == @writ REMOVE r IN redirects`,
+		obj{"writ": writKey},
+	)
+	return err
+}
+
+// arangoSeriesStore keeps series in the series collection
+type arangoSeriesStore struct{}
+
+// querySeries run a query returning series
+func querySeries(query string, vars obj) ([]Series, error) {
+	ctx := context.Background()
+	cursor, err := DB.Query(ctx, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	all := []Series{}
+	for {
+		var s Series
+		_, err = cursor.ReadDocument(ctx, &s)
+		if driver.IsNoMoreDocuments(err) {
+			return all, nil
+		} else if err != nil {
+			return all, err
+		}
+		all = append(all, s)
+	}
+}
+
+func (arangoSeriesStore) All() ([]Series, error) {
+	return querySeries(`FOR s IN series SORT s.title RETURN s`, obj{})
+}
+
+func (arangoSeriesStore) ByKey(key string) (Series, error) {
+	var s Series
+	_, err := WritSeries.ReadDocument(context.Background(), key, &s)
+	return s, err
+}
+
+func (arangoSeriesStore) BySlug(slug string) (Series, error) {
+	var s Series
+	err := QueryOne(`FOR s IN series FILTER s.slug == @slug RETURN s`, obj{"slug": slug}, &s)
+	return s, err
+}
+
+func (arangoSeriesStore) Containing(writKey string) ([]Series, error) {
+	return querySeries(`FOR s IN series FILTER @writ IN s.writs SORT s.created RETURN s`, obj{"writ": writKey})
+}
+
+func (arangoSeriesStore) Create(s *Series) error {
+	meta, err := WritSeries.CreateDocument(driver.WithWaitForSync(context.Background()), s)
+	if driver.IsConflict(err) {
+		return ErrConflict
+	}
+	s.Key = meta.Key
+	return err
+}
+
+func (arangoSeriesStore) Replace(s *Series) error {
+	_, err := WritSeries.ReplaceDocument(driver.WithWaitForSync(context.Background()), s.Key, s)
+	if driver.IsConflict(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (arangoSeriesStore) Remove(key string) error {
+	_, err := WritSeries.RemoveDocument(driver.WithWaitForSync(context.Background()), key)
+	return err
+}
+
+func (arangoSeriesStore) RemoveWrit(writKey string) error {
+	_, err := DB.Query(
+		driver.WithWaitForSync(context.Background()),
+		`FOR s IN series FILTER @writ IN s.writs UPDATE s WITH {writs: REMOVE_VALUE(s.writs, @writ)} IN series`,
+		obj{"writ": writKey},
+	)
+	return err
+}
+
+// arangoMediaStore keeps the details of uploads in the media collection
+type arangoMediaStore struct{}
+
+func (arangoMediaStore) List(search string, unused bool) ([]MediaFile, error) {
+	ctx := context.Background()
+	cursor, err := DB.Query(ctx, `FOR m IN media
+	FILTER @search == "" || CONTAINS(LOWER(m.name), @search) || CONTAINS(m.mime, @search)
+	FILTER !@unused || LENGTH(m.writs) == 0
+	SORT m.uploaded DESC
+	RETURN m`, obj{"search": search, "unused": unused})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	list := []MediaFile{}
+	for {
+		var m MediaFile
+		_, err = cursor.ReadDocument(ctx, &m)
+		if driver.IsNoMoreDocuments(err) {
+			return list, nil
+		} else if err != nil {
+			return list, err
+		}
+		list = append(list, m)
+	}
+}
+
+func (arangoMediaStore) ByKey(key string) (MediaFile, error) {
+	var m MediaFile
+	_, err := Media.ReadDocument(context.Background(), key, &m)
+	return m, err
+}
+
+func (arangoMediaStore) Create(m *MediaFile) error {
+	_, err := Media.CreateDocument(driver.WithWaitForSync(context.Background()), m)
+	if driver.IsConflict(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (arangoMediaStore) Remove(key string) error {
+	_, err := Media.RemoveDocument(driver.WithWaitForSync(context.Background()), key)
+	return err
+}
+
+func (arangoMediaStore) SetWritRefs(writKey string, mediaKeys []string) error {
+	ctx := driver.WithWaitForSync(context.Background())
+	vars := obj{"writ": writKey, "keys": mediaKeys}
+	_, err := DB.Query(ctx, `FOR m IN media FILTER @writ IN m.writs && m._key NOT IN @keys
+	UPDATE m WITH {writs: REMOVE_VALUE(m.writs, @writ)} IN media`, vars)
+	if err != nil || len(mediaKeys) == 0 {
+		return err
+	}
+	_, err = DB.Query(ctx, `FOR m IN media FILTER m._key IN @keys && @writ NOT IN m.writs
+	UPDATE m WITH {writs: PUSH(m.writs, @writ)} IN media`, vars)
+	return err
+}
+
+// arangoCommentStore keeps comments in the comments collection
+type arangoCommentStore struct{}
+
+func (arangoCommentStore) ByKey(key string) (Comment, error) {
+	var comment Comment
+	_, err := Comments.ReadDocument(context.Background(), key, &comment)
+	return comment, err
+}
+
+func (arangoCommentStore) ByWrit(writKey string) ([]Comment, error) {
+	query := `FOR c IN comments FILTER c.writkey == @writ SORT c.created ASC RETURN UNSET(c, "markdown")`
+	ctx := driver.WithQueryCount(context.Background())
+	cursor, err := DB.Query(ctx, query, obj{"writ": writKey})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	comments := []Comment{}
+	for {
+		var comment Comment
+		_, err = cursor.ReadDocument(ctx, &comment)
+		if driver.IsNoMoreDocuments(err) {
+			return comments, nil
+		} else if err != nil {
+			return comments, err
+		}
+		comments = append(comments, comment)
+	}
+}
+
+func (arangoCommentStore) Count(writKeys []string) (map[string]int64, error) {
+	counts := map[string]int64{}
+	results, err := Query(
+		`FOR c IN comments FILTER c.writkey IN @writs COLLECT writ = c.writkey WITH COUNT INTO total RETURN {writ, total}`,
+		obj{"writs": writKeys},
+	)
+	if err != nil && !driver.IsNoMoreDocuments(err) {
+		return counts, err
+	}
+	for _, result := range results {
+		writ, _ := result["writ"].(string)
+		switch total := result["total"].(type) {
+		case float64:
+			counts[writ] = int64(total)
+		case int64:
+			counts[writ] = total
+		}
+	}
+	return counts, nil
+}
+
+func (arangoCommentStore) Create(c *Comment) error {
+	meta, err := Comments.CreateDocument(driver.WithWaitForSync(context.Background(), true), c)
+	c.Key = meta.Key
+	return err
+}
+
+func (arangoCommentStore) HasReplies(key string) (bool, error) {
+	var replies int64
+	err := QueryOne(
+		`RETURN LENGTH(FOR c IN comments FILTER c.parent == @key LIMIT 1 RETURN 1)`,
+		obj{"key": key},
+		&replies,
+	)
+	return replies != 0, err
+}
+
+func (arangoCommentStore) Update(key string, changes obj) error {
+	_, err := Comments.UpdateDocument(driver.WithWaitForSync(context.Background(), true), key, changes)
+	return err
+}
+
+func (arangoCommentStore) Remove(key string) error {
+	_, err := Comments.RemoveDocument(driver.WithWaitForSync(context.Background(), true), key)
+	return err
+}
+
+func (arangoCommentStore) RemoveWrit(writKey string) error {
+	_, err := DB.Query(
+		driver.WithWaitForSync(context.Background()),
+		`FOR c IN comments FILTER c.writkey == @writ REMOVE c IN comments`,
+		obj{"writ": writKey},
+	)
+	return err
+}
+
+// arangoRevisionStore keeps prior versions of writs in the writ_revisions collection
+type arangoRevisionStore struct{}
+
+func (arangoRevisionStore) ByKey(key string) (WritRevision, error) {
+	var rev WritRevision
+	_, err := WritRevisions.ReadDocument(context.Background(), key, &rev)
+	return rev, err
+}
+
+func (arangoRevisionStore) ByWrit(writKey string) ([]WritRevision, error) {
+	query := `FOR r IN writ_revisions FILTER r.writkey == @writ SORT r.replaced DESC RETURN UNSET(r, "markdown")`
+	ctx := driver.WithQueryCount(context.Background())
+	cursor, err := DB.Query(ctx, query, obj{"writ": writKey})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	revs := []WritRevision{}
+	for {
+		var rev WritRevision
+		_, err = cursor.ReadDocument(ctx, &rev)
+		if driver.IsNoMoreDocuments(err) {
+			return revs, nil
+		} else if err != nil {
+			return revs, err
+		}
+		revs = append(revs, rev)
+	}
+}
+
+func (arangoRevisionStore) Create(r *WritRevision) error {
+	meta, err := WritRevisions.CreateDocument(driver.WithWaitForSync(context.Background(), true), r)
+	r.Key = meta.Key
+	return err
+}
+
+func (arangoRevisionStore) RemoveWrit(writKey string) error {
+	_, err := DB.Query(
+		driver.WithWaitForSync(context.Background()),
+		`FOR r IN writ_revisions FILTER r.writkey == @writ REMOVE r IN writ_revisions`,
+		obj{"writ": writKey},
+	)
+	return err
+}
+
+// arangoDocumentStore raw access to the app's collections in arangodb
+type arangoDocumentStore struct{}
+
+func (arangoDocumentStore) Collections() []string {
+	return []string{"users", "writs", "logs", "ratelimits", "redirects", "series", "media", "comments", "writ_revisions"}
+}
+
+func (arangoDocumentStore) Each(collection string, fn func(doc obj) error) error {
+	ctx := context.Background()
+	cursor, err := DB.Query(ctx, `FOR d IN @@collection RETURN d`, obj{"@collection": collection})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	for {
+		var doc obj
+		_, err = cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err = fn(doc); err != nil {
+			return err
+		}
+	}
+}
+
+func (arangoDocumentStore) Put(collection string, doc obj, overwrite bool) (bool, error) {
+	ctx := driver.WithWaitForSync(context.Background())
+	coll, err := DB.Collection(ctx, collection)
+	if err != nil {
+		return false, err
+	}
+	delete(doc, "_id")
+	delete(doc, "_rev")
+
+	_, err = coll.CreateDocument(ctx, doc)
+	if !driver.IsConflict(err) {
+		return false, err
+	}
+
+	key, _ := doc["_key"].(string)
+	if !overwrite || len(key) == 0 {
+		return false, ErrConflict
+	}
+	if exists, err := coll.DocumentExists(ctx, key); err != nil {
+		return false, err
+	} else if !exists {
+		// it's clashing with another document's unique fields
+		return false, ErrConflict
+	}
+	_, err = coll.ReplaceDocument(ctx, key, doc)
+	if driver.IsConflict(err) {
+		return false, ErrConflict
+	}
+	return err == nil, err
+}
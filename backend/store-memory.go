@@ -0,0 +1,1345 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxMemoryLogs how many request logs the embedded store holds on to
+const MaxMemoryLogs = 10000
+
+// memoryStore is the embedded, pure Go store, it keeps everything in memory
+// as json-like documents and periodically writes them out to a single file
+type memoryStore struct {
+	Seq        int64                   `json:"seq"`
+	Users      map[string]obj          `json:"users"`
+	Writs      map[string]obj          `json:"writs"`
+	RateLimits map[string]ratelimit    `json:"ratelimits"`
+	Redirects  map[string]redirect     `json:"redirects"`
+	Series     map[string]Series       `json:"series"`
+	Media      map[string]MediaFile    `json:"media"`
+	Comments   map[string]Comment      `json:"comments"`
+	Revisions  map[string]WritRevision `json:"writ_revisions"`
+	Logs       []LogEntry              `json:"logs"`
+
+	location string
+	dirty    bool
+	sync.RWMutex
+}
+
+// MemStore the embedded store, only set when the app isn't using arangodb
+var MemStore *memoryStore
+
+func setupMemoryStore(location string) error {
+	store := &memoryStore{
+		Users:      map[string]obj{},
+		Writs:      map[string]obj{},
+		RateLimits: map[string]ratelimit{},
+		Redirects:  map[string]redirect{},
+		Series:     map[string]Series{},
+		Media:      map[string]MediaFile{},
+		Comments:   map[string]Comment{},
+		Revisions:  map[string]WritRevision{},
+		Logs:       []LogEntry{},
+		location:   location,
+	}
+
+	data, err := ioutil.ReadFile(location)
+	if err == nil {
+		err = json.Unmarshal(data, store)
+		if err != nil {
+			return fmt.Errorf("the embedded store at %s is corrupt: %v", location, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	} else if err = os.MkdirAll(filepath.Dir(location), 0700); err != nil {
+		return err
+	}
+
+	MemStore = store
+	Stores.Users = memoryUserStore{store}
+	Stores.Writs = memoryWritStore{store}
+	Stores.Logs = memoryLogStore{store}
+	Stores.RateLimits = memoryRateLimitStore{store}
+	Stores.Redirects = memoryRedirectStore{store}
+	Stores.Series = memorySeriesStore{store}
+	Stores.Media = memoryMediaStore{store}
+	Stores.Comments = memoryCommentStore{store}
+	Stores.Revisions = memoryRevisionStore{store}
+	Stores.Documents = memoryDocumentStore{store}
+
+	go func() {
+		for range time.Tick(2 * time.Second) {
+			if err := store.Flush(); err != nil {
+				fmt.Println("embedded store: trouble writing to disk - ", err)
+			}
+		}
+	}()
+
+	DBAlive = true
+	return nil
+}
+
+// Flush write the store out to its file, if anything changed
+func (s *memoryStore) Flush() error {
+	s.Lock()
+	if !s.dirty {
+		s.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s)
+	s.dirty = false
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.location + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.location)
+}
+
+// nextKey a fresh document key, like arangodb's they're numeric strings
+func (s *memoryStore) nextKey() string {
+	s.Seq++
+	return strconv.FormatInt(s.Seq, 10)
+}
+
+// toDoc turn a value into a json-like document
+func toDoc(value interface{}) (obj, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	doc := obj{}
+	err = json.Unmarshal(data, &doc)
+	return doc, err
+}
+
+// fromDoc turn a json-like document into a value, leaving out some fields
+func fromDoc(doc obj, value interface{}, omissions ...string) error {
+	if len(omissions) != 0 {
+		trimmed := make(obj, len(doc))
+		for k, v := range doc {
+			trimmed[k] = v
+		}
+		for _, omission := range omissions {
+			delete(trimmed, omission)
+		}
+		doc = trimmed
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// mergeDoc merge changes into doc the way arangodb does with mergeObjects and keepNull off
+func mergeDoc(doc, changes obj) {
+	for k, v := range changes {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+		sub, ok := v.(map[string]interface{})
+		if existing, isobj := doc[k].(map[string]interface{}); ok && isobj {
+			mergeDoc(existing, sub)
+			continue
+		}
+		doc[k] = v
+	}
+}
+
+// clampLimit keep a query's offset or count between 0 and n
+func clampLimit(limit int64, n int) int {
+	if limit < 0 {
+		return 0
+	}
+	if limit > int64(n) {
+		return n
+	}
+	return int(limit)
+}
+
+// memoryUserStore users in the embedded store
+type memoryUserStore struct{ s *memoryStore }
+
+func (m memoryUserStore) find(match func(u *User) bool) (User, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	return m.lookup(match)
+}
+
+// lookup the first user to match, the lock must be held
+func (m memoryUserStore) lookup(match func(u *User) bool) (User, error) {
+	for _, doc := range m.s.Users {
+		var user User
+		if fromDoc(doc, &user) == nil && match(&user) {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m memoryUserStore) ByKey(key string) (User, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	var user User
+	doc, ok := m.s.Users[key]
+	if !ok {
+		return user, ErrNotFound
+	}
+	err := fromDoc(doc, &user)
+	return user, err
+}
+
+func (m memoryUserStore) ByUsername(username string) (User, error) {
+	return m.find(func(u *User) bool { return u.Username == username })
+}
+
+func (m memoryUserStore) ByEmail(email string) (User, error) {
+	return m.find(func(u *User) bool { return u.Email == email })
+}
+
+func (m memoryUserStore) ByDetails(email, username string) (User, error) {
+	return m.find(func(u *User) bool { return u.Email == email && u.Username == username })
+}
+
+func (m memoryUserStore) Create(user *User) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	if _, err := m.lookup(func(u *User) bool {
+		return u.Username == user.Username || u.Email == user.Email
+	}); err == nil {
+		return ErrConflict
+	}
+
+	user.Key = m.s.nextKey()
+	if user.Logins == nil {
+		user.Logins = []time.Time{}
+	}
+	if user.Sessions == nil {
+		user.Sessions = []time.Time{}
+	}
+	doc, err := toDoc(user)
+	if err != nil {
+		return err
+	}
+	m.s.Users[user.Key] = doc
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryUserStore) Update(key string, changes obj) (User, error) {
+	var user User
+	changedoc, err := toDoc(changes)
+	if err != nil {
+		return user, err
+	}
+
+	m.s.Lock()
+	defer m.s.Unlock()
+	doc, ok := m.s.Users[key]
+	if !ok {
+		return user, ErrNotFound
+	}
+	mergeDoc(doc, changedoc)
+	m.s.dirty = true
+	err = fromDoc(doc, &user)
+	return user, err
+}
+
+// modifyArray change an array field of a user's document under the lock, the values
+// are turned into what they'd look like in the document so they compare properly
+func (m memoryUserStore) modifyArray(key, field string, values []interface{}, modify func(list, values []interface{}) []interface{}) (User, error) {
+	var user User
+	var normalized []interface{}
+	data, err := json.Marshal(values)
+	if err == nil {
+		err = json.Unmarshal(data, &normalized)
+	}
+	if err != nil {
+		return user, err
+	}
+
+	m.s.Lock()
+	defer m.s.Unlock()
+	doc, ok := m.s.Users[key]
+	if !ok {
+		return user, ErrNotFound
+	}
+	list, _ := doc[field].([]interface{})
+	doc[field] = modify(list, normalized)
+	m.s.dirty = true
+	err = fromDoc(doc, &user)
+	return user, err
+}
+
+// indexOfValue where a value is in a document's array, -1 if it isn't
+func indexOfValue(list []interface{}, value interface{}) int {
+	for i, item := range list {
+		if reflect.DeepEqual(item, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m memoryUserStore) Append(key, field string, values ...interface{}) (User, error) {
+	return m.modifyArray(key, field, values, func(list, values []interface{}) []interface{} {
+		for _, value := range values {
+			if indexOfValue(list, value) == -1 {
+				list = append(list, value)
+			}
+		}
+		return list
+	})
+}
+
+func (m memoryUserStore) RemoveValues(key, field string, values ...interface{}) (User, error) {
+	return m.modifyArray(key, field, values, func(list, values []interface{}) []interface{} {
+		kept := []interface{}{}
+		for _, item := range list {
+			if indexOfValue(values, item) == -1 {
+				kept = append(kept, item)
+			}
+		}
+		return kept
+	})
+}
+
+func (m memoryUserStore) Subscribers() ([]User, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	users := []User{}
+	for _, doc := range m.s.Users {
+		var user User
+		if fromDoc(doc, &user) == nil && user.Subscriber {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// memoryWritStore writs in the embedded store
+type memoryWritStore struct{ s *memoryStore }
+
+// matchWrit the embedded version of the FILTERs that arangoWritStore builds,
+// one says if it's matching for QueryOne or Query, they filter slightly differently
+func matchWrit(w *Writ, q *WritQuery, one bool) bool {
+	if q.PrivateOnly {
+		if w.Public {
+			return false
+		}
+	} else if !q.IncludePrivate && !w.Public {
+		return false
+	}
+
+	if q.MembersOnly {
+		if !w.MembersOnly {
+			return false
+		}
+	} else if !one && !q.IncludeMembersOnly && w.MembersOnly {
+		return false
+	}
+
+	if q.restricted && CanViewWrit(w, q.viewer) != nil {
+		return false
+	}
+
+	if !one {
+		if !q.Between.Start.IsZero() && !w.Created.After(q.Between.Start) {
+			return false
+		}
+		if !q.Between.End.IsZero() && !w.Created.Before(q.Between.End) {
+			return false
+		}
+	} else {
+		if !q.Created.IsZero() && !w.Created.Equal(q.Created) {
+			return false
+		}
+		if len(q.Key) > 0 && w.Key != q.Key {
+			return false
+		}
+		if len(q.Slug) > 0 && w.Slug != q.Slug {
+			return false
+		}
+		if len(q.Title) > 0 && w.Title != q.Title {
+			return false
+		}
+	}
+
+	if len(q.Author) > 0 && w.Author != q.Author {
+		return false
+	}
+	if len(q.ViewedBy) > 0 && !stringsContain(w.ViewedBy, q.ViewedBy) {
+		return false
+	}
+	if len(q.LikedBy) > 0 && !stringsContain(w.LikedBy, q.LikedBy) {
+		return false
+	}
+	for _, role := range q.Roles {
+		if !rolesContain(w.Roles, role) {
+			return false
+		}
+	}
+	for _, tag := range q.Tags {
+		if !stringsContain(w.Tags, tag) {
+			return false
+		}
+	}
+	if len(q.Series) > 0 && !stringsContain(q.seriesWrits, w.Key) {
+		return false
+	}
+	if r := q.relatedTo; r != nil && (w.Key == r.Key || overlap(w.Tags, r.Tags) == 0 && overlap(w.LikedBy, r.LikedBy) == 0) {
+		return false
+	}
+
+	if len(q.State) > 0 {
+		state := w.State
+		if len(state) == 0 {
+			// writs from before there were states are either published or drafts
+			state = WritDraft
+			if w.Public {
+				state = WritPublished
+			}
+		}
+		if state != q.State {
+			return false
+		}
+	}
+
+	return true
+}
+
+// searchScore a rough stand in for BM25, counting term hits with titles and tags weighing more
+func searchScore(w *Writ, terms []string) int {
+	title := strings.ToLower(w.Title)
+	desc := strings.ToLower(w.Description)
+	markdown := strings.ToLower(w.Markdown)
+	score := 0
+	for _, term := range terms {
+		score += 3*strings.Count(title, term) + 2*strings.Count(desc, term) + strings.Count(markdown, term)
+		for _, tag := range w.Tags {
+			if strings.ToLower(tag) == term {
+				score += 3
+			}
+		}
+	}
+	return score
+}
+
+func (m memoryWritStore) Query(q *WritQuery) ([]Writ, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+
+	type hit struct {
+		writ  Writ
+		doc   obj
+		score int
+	}
+	terms := searchTerms(q.Search)
+
+	hits := []hit{}
+	for _, doc := range m.s.Writs {
+		var writ Writ
+		if err := fromDoc(doc, &writ); err != nil {
+			return nil, err
+		}
+		if !matchWrit(&writ, q, false) {
+			continue
+		}
+		h := hit{writ: writ, doc: doc}
+		if len(q.Search) > 0 {
+			if h.score = searchScore(&writ, terms); h.score == 0 {
+				continue
+			}
+		}
+		hits = append(hits, h)
+	}
+
+	if len(q.Search) > 0 {
+		sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	} else if len(q.Series) > 0 && !q.DontSort {
+		sort.SliceStable(hits, func(i, j int) bool {
+			return seriesPosition(q.seriesWrits, hits[i].writ.Key) < seriesPosition(q.seriesWrits, hits[j].writ.Key)
+		})
+	} else if !q.DontSort {
+		sort.SliceStable(hits, func(i, j int) bool { return hits[i].writ.Created.After(hits[j].writ.Created) })
+	}
+
+	if len(q.Limit) == 1 {
+		if count := clampLimit(q.Limit[0], len(hits)); count < len(hits) {
+			hits = hits[:count]
+		}
+	} else if len(q.Limit) == 2 {
+		offset, count := clampLimit(q.Limit[0], len(hits)), clampLimit(q.Limit[1], len(hits))
+		if offset+count < len(hits) {
+			hits = hits[offset : offset+count]
+		} else {
+			hits = hits[offset:]
+		}
+	}
+
+	writs := make([]Writ, 0, len(hits))
+	for _, h := range hits {
+		var writ Writ
+		if err := fromDoc(h.doc, &writ, q.Omissions...); err != nil {
+			return writs, err
+		}
+		writs = append(writs, writ)
+	}
+	return writs, nil
+}
+
+// identifiedBy whether a document has the key, slug and title a single writ query asks for,
+// it's checked before decoding so a page load doesn't decode every writ
+func identifiedBy(doc obj, q *WritQuery) bool {
+	if len(q.Key) > 0 && doc["_key"] != q.Key {
+		return false
+	}
+	if len(q.Slug) > 0 && doc["slug"] != q.Slug {
+		return false
+	}
+	return len(q.Title) == 0 || doc["title"] == q.Title
+}
+
+func (m memoryWritStore) QueryOne(q *WritQuery) (Writ, error) {
+	writ, key, err := m.queryOne(q)
+	if err != nil || !q.UpdateViews {
+		return writ, err
+	}
+
+	m.s.Lock()
+	defer m.s.Unlock()
+	doc, ok := m.s.Writs[key]
+	if !ok {
+		// it was removed in the meantime
+		return writ, nil
+	}
+	var counts struct {
+		Views    int64    `json:"views"`
+		ViewedBy []string `json:"viewedby"`
+	}
+	if err = fromDoc(obj{"views": doc["views"], "viewedby": doc["viewedby"]}, &counts); err != nil {
+		return writ, err
+	}
+	if len(q.Viewer) > 0 {
+		if stringsContain(counts.ViewedBy, q.Viewer) {
+			return writ, nil
+		}
+		doc["viewedby"] = append(counts.ViewedBy, q.Viewer)
+	} else {
+		doc["views"] = counts.Views + 1
+	}
+	// like arangodb any write moves _rev on, it's only editrev that edits alone move
+	doc["_rev"] = RandStr(11)
+	m.s.dirty = true
+	return writ, nil
+}
+
+// queryOne find the writ a query is after and its key without changing anything
+func (m memoryWritStore) queryOne(q *WritQuery) (Writ, string, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+
+	var writ Writ
+	for _, doc := range m.s.Writs {
+		if !identifiedBy(doc, q) {
+			continue
+		}
+		var candidate Writ
+		if err := fromDoc(doc, &candidate); err != nil {
+			return writ, "", err
+		}
+		if !matchWrit(&candidate, q, true) {
+			continue
+		}
+		err := fromDoc(doc, &writ, q.Omissions...)
+		return writ, candidate.Key, err
+	}
+	return writ, "", ErrNotFound
+}
+
+func (m memoryWritStore) ByKey(key string) (Writ, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	var writ Writ
+	doc, ok := m.s.Writs[key]
+	if !ok {
+		return writ, ErrNotFound
+	}
+	err := fromDoc(doc, &writ)
+	return writ, err
+}
+
+// sameWrit whether two writ documents clash on the unique index arangodb keeps,
+// the title, tags and slug taken together
+func sameWrit(a, b obj) bool {
+	return a["title"] == b["title"] && a["slug"] == b["slug"] && reflect.DeepEqual(a["tags"], b["tags"])
+}
+
+// clashes whether a writ other than the one under key has the same title, tags and slug as doc,
+// the lock must be held
+func (m memoryWritStore) clashes(key string, doc obj) bool {
+	for other, existing := range m.s.Writs {
+		if other != key && sameWrit(existing, doc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m memoryWritStore) Create(w *Writ) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+
+	doc, err := toDoc(w)
+	if err != nil {
+		return err
+	}
+	if m.clashes("", doc) {
+		return ErrConflict
+	}
+
+	w.Key = m.s.nextKey()
+	w.Rev, w.EditRev = RandStr(11), RandStr(11)
+	doc["_key"], doc["_rev"], doc["editrev"] = w.Key, w.Rev, w.EditRev
+	m.s.Writs[w.Key] = doc
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryWritStore) Update(key, editrev string, changes obj) (string, error) {
+	changedoc, err := toDoc(changes)
+	if err != nil {
+		return "", err
+	}
+	delete(changedoc, "_key")
+	delete(changedoc, "_rev")
+	delete(changedoc, "editrev")
+
+	m.s.Lock()
+	defer m.s.Unlock()
+	doc, ok := m.s.Writs[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if len(editrev) != 0 && doc["editrev"] != editrev {
+		return "", ErrStaleRevision
+	}
+	// merged into a copy first, so a clash leaves the writ as it was
+	doc, err = toDoc(doc)
+	if err != nil {
+		return "", err
+	}
+	mergeDoc(doc, changedoc)
+	if m.clashes(key, doc) {
+		return "", ErrConflict
+	}
+	newrev := RandStr(11)
+	doc["_rev"], doc["editrev"] = RandStr(11), newrev
+	m.s.Writs[key] = doc
+	m.s.dirty = true
+	return newrev, nil
+}
+
+func (m memoryWritStore) ToggleLike(slug, userKey string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	for _, doc := range m.s.Writs {
+		if doc["slug"] != slug {
+			continue
+		}
+		var writ Writ
+		if err := fromDoc(doc, &writ); err != nil {
+			return err
+		}
+		liked := []string{}
+		for _, key := range writ.LikedBy {
+			if key != userKey {
+				liked = append(liked, key)
+			}
+		}
+		if len(liked) == len(writ.LikedBy) {
+			liked = append(liked, userKey)
+		}
+		doc["likedby"] = liked
+		doc["_rev"] = RandStr(11)
+		m.s.dirty = true
+	}
+	return nil
+}
+
+func (m memoryWritStore) ClaimNotification(key string) (bool, error) {
+	m.s.Lock()
+	defer m.s.Unlock()
+	doc, ok := m.s.Writs[key]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if notified, _ := doc["notified"].(bool); notified {
+		return false, nil
+	}
+	doc["notified"], doc["_rev"] = true, RandStr(11)
+	m.s.dirty = true
+	return true, nil
+}
+
+func (m memoryWritStore) Remove(key string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	if _, ok := m.s.Writs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.s.Writs, key)
+	m.s.dirty = true
+	return nil
+}
+
+// memoryLogStore request logs in the embedded store
+type memoryLogStore struct{ s *memoryStore }
+
+func (m memoryLogStore) Add(entries []LogEntry) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	m.s.Logs = append(m.s.Logs, entries...)
+	if len(m.s.Logs) > MaxMemoryLogs {
+		m.s.Logs = m.s.Logs[len(m.s.Logs)-MaxMemoryLogs:]
+	}
+	m.s.dirty = true
+	return nil
+}
+
+// memoryRateLimitStore ratelimits in the embedded store
+type memoryRateLimitStore struct{ s *memoryStore }
+
+func (m memoryRateLimitStore) Hit(key string, start int64) (ratelimit, error) {
+	m.s.Lock()
+	defer m.s.Unlock()
+	limit, ok := m.s.RateLimits[key]
+	if ok {
+		limit.Count++
+	} else {
+		limit = ratelimit{Key: key, Start: start}
+	}
+	m.s.RateLimits[key] = limit
+	m.s.dirty = true
+	return limit, nil
+}
+
+func (m memoryRateLimitStore) Penalize(key string, start int64) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	limit, ok := m.s.RateLimits[key]
+	if !ok {
+		return ErrNotFound
+	}
+	limit.Count++
+	limit.Start = start
+	m.s.RateLimits[key] = limit
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryRateLimitStore) Reset(key string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	delete(m.s.RateLimits, key)
+	m.s.dirty = true
+	return nil
+}
+
+// memoryRedirectStore old paths in the embedded store
+type memoryRedirectStore struct{ s *memoryStore }
+
+func (m memoryRedirectStore) Add(path, writKey string) error {
+	path = redirectPath(path)
+	key := redirectKey(path)
+
+	m.s.Lock()
+	defer m.s.Unlock()
+	r, ok := m.s.Redirects[key]
+	if !ok {
+		r = redirect{Key: key, Path: path, Created: time.Now()}
+	}
+	r.Writ = writKey
+	m.s.Redirects[key] = r
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryRedirectStore) Lookup(path string) (string, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	r, ok := m.s.Redirects[redirectKey(redirectPath(path))]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return r.Writ, nil
+}
+
+func (m memoryRedirectStore) RemoveWrit(writKey string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	for key, r := range m.s.Redirects {
+		if r.Writ == writKey {
+			delete(m.s.Redirects, key)
+			m.s.dirty = true
+		}
+	}
+	return nil
+}
+
+// memorySeriesStore series in the embedded store
+type memorySeriesStore struct{ s *memoryStore }
+
+// sorted the series matching a filter, ordered by less
+func (m memorySeriesStore) sorted(match func(s *Series) bool, less func(a, b *Series) bool) []Series {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	all := []Series{}
+	for _, s := range m.s.Series {
+		if match(&s) {
+			all = append(all, s)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return less(&all[i], &all[j]) })
+	return all
+}
+
+func (m memorySeriesStore) All() ([]Series, error) {
+	return m.sorted(
+		func(s *Series) bool { return true },
+		func(a, b *Series) bool { return a.Title < b.Title },
+	), nil
+}
+
+func (m memorySeriesStore) ByKey(key string) (Series, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	s, ok := m.s.Series[key]
+	if !ok {
+		return s, ErrNotFound
+	}
+	return s, nil
+}
+
+func (m memorySeriesStore) BySlug(slug string) (Series, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	for _, s := range m.s.Series {
+		if s.Slug == slug {
+			return s, nil
+		}
+	}
+	return Series{}, ErrNotFound
+}
+
+func (m memorySeriesStore) Containing(writKey string) ([]Series, error) {
+	return m.sorted(
+		func(s *Series) bool { return stringsContain(s.Writs, writKey) },
+		func(a, b *Series) bool { return a.Created.Before(b.Created) },
+	), nil
+}
+
+// clashes whether another series already has the title or slug of s, the lock must be held
+func (m memorySeriesStore) clashes(s *Series) bool {
+	for key, other := range m.s.Series {
+		if key != s.Key && (other.Title == s.Title || other.Slug == s.Slug) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m memorySeriesStore) Create(s *Series) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	if m.clashes(s) {
+		return ErrConflict
+	}
+	s.Key = m.s.nextKey()
+	m.s.Series[s.Key] = *s
+	m.s.dirty = true
+	return nil
+}
+
+func (m memorySeriesStore) Replace(s *Series) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	if _, ok := m.s.Series[s.Key]; !ok {
+		return ErrNotFound
+	}
+	if m.clashes(s) {
+		return ErrConflict
+	}
+	m.s.Series[s.Key] = *s
+	m.s.dirty = true
+	return nil
+}
+
+func (m memorySeriesStore) Remove(key string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	if _, ok := m.s.Series[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.s.Series, key)
+	m.s.dirty = true
+	return nil
+}
+
+func (m memorySeriesStore) RemoveWrit(writKey string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	for key, s := range m.s.Series {
+		if i := seriesPosition(s.Writs, writKey); i != -1 {
+			s.Writs = append(s.Writs[:i:i], s.Writs[i+1:]...)
+			m.s.Series[key] = s
+			m.s.dirty = true
+		}
+	}
+	return nil
+}
+
+// memoryMediaStore the details of uploads in the embedded store
+type memoryMediaStore struct{ s *memoryStore }
+
+func (m memoryMediaStore) List(search string, unused bool) ([]MediaFile, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	list := []MediaFile{}
+	for _, media := range m.s.Media {
+		if unused && len(media.Writs) != 0 {
+			continue
+		}
+		if len(search) != 0 && !strings.Contains(strings.ToLower(media.Name), search) && !strings.Contains(media.Mime, search) {
+			continue
+		}
+		list = append(list, media)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Uploaded.After(list[j].Uploaded) })
+	return list, nil
+}
+
+func (m memoryMediaStore) ByKey(key string) (MediaFile, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	media, ok := m.s.Media[key]
+	if !ok {
+		return media, ErrNotFound
+	}
+	return media, nil
+}
+
+func (m memoryMediaStore) Create(media *MediaFile) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	if _, ok := m.s.Media[media.Key]; ok {
+		return ErrConflict
+	}
+	m.s.Media[media.Key] = *media
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryMediaStore) Remove(key string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	if _, ok := m.s.Media[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.s.Media, key)
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryMediaStore) SetWritRefs(writKey string, mediaKeys []string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	for key, media := range m.s.Media {
+		i := seriesPosition(media.Writs, writKey)
+		linked := stringsContain(mediaKeys, key)
+		if linked && i == -1 {
+			media.Writs = append(media.Writs, writKey)
+		} else if !linked && i != -1 {
+			media.Writs = append(media.Writs[:i:i], media.Writs[i+1:]...)
+		} else {
+			continue
+		}
+		m.s.Media[key] = media
+		m.s.dirty = true
+	}
+	return nil
+}
+
+// memoryCommentStore comments in the embedded store
+type memoryCommentStore struct{ s *memoryStore }
+
+func (m memoryCommentStore) ByKey(key string) (Comment, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	comment, ok := m.s.Comments[key]
+	if !ok {
+		return comment, ErrNotFound
+	}
+	return comment, nil
+}
+
+func (m memoryCommentStore) ByWrit(writKey string) ([]Comment, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	comments := []Comment{}
+	for _, comment := range m.s.Comments {
+		if comment.WritKey == writKey {
+			comment.Markdown = ""
+			comments = append(comments, comment)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].Created.Before(comments[j].Created) })
+	return comments, nil
+}
+
+func (m memoryCommentStore) Count(writKeys []string) (map[string]int64, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	counts := map[string]int64{}
+	for _, comment := range m.s.Comments {
+		if stringsContain(writKeys, comment.WritKey) {
+			counts[comment.WritKey]++
+		}
+	}
+	return counts, nil
+}
+
+func (m memoryCommentStore) Create(c *Comment) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	c.Key = m.s.nextKey()
+	m.s.Comments[c.Key] = *c
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryCommentStore) HasReplies(key string) (bool, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	for _, comment := range m.s.Comments {
+		if comment.Parent == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m memoryCommentStore) Update(key string, changes obj) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	comment, ok := m.s.Comments[key]
+	if !ok {
+		return ErrNotFound
+	}
+	doc, err := toDoc(comment)
+	if err != nil {
+		return err
+	}
+	mergeDoc(doc, changes)
+	var updated Comment
+	if err = fromDoc(doc, &updated); err != nil {
+		return err
+	}
+	m.s.Comments[key] = updated
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryCommentStore) Remove(key string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	if _, ok := m.s.Comments[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.s.Comments, key)
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryCommentStore) RemoveWrit(writKey string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	for key, comment := range m.s.Comments {
+		if comment.WritKey == writKey {
+			delete(m.s.Comments, key)
+			m.s.dirty = true
+		}
+	}
+	return nil
+}
+
+// memoryRevisionStore prior versions of writs in the embedded store
+type memoryRevisionStore struct{ s *memoryStore }
+
+func (m memoryRevisionStore) ByKey(key string) (WritRevision, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	rev, ok := m.s.Revisions[key]
+	if !ok {
+		return rev, ErrNotFound
+	}
+	return rev, nil
+}
+
+func (m memoryRevisionStore) ByWrit(writKey string) ([]WritRevision, error) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	revs := []WritRevision{}
+	for _, rev := range m.s.Revisions {
+		if rev.WritKey == writKey {
+			rev.Markdown = ""
+			revs = append(revs, rev)
+		}
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Replaced.After(revs[j].Replaced) })
+	return revs, nil
+}
+
+func (m memoryRevisionStore) Create(r *WritRevision) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	r.Key = m.s.nextKey()
+	m.s.Revisions[r.Key] = *r
+	m.s.dirty = true
+	return nil
+}
+
+func (m memoryRevisionStore) RemoveWrit(writKey string) error {
+	m.s.Lock()
+	defer m.s.Unlock()
+	for key, rev := range m.s.Revisions {
+		if rev.WritKey == writKey {
+			delete(m.s.Revisions, key)
+			m.s.dirty = true
+		}
+	}
+	return nil
+}
+
+// memoryDocumentStore raw access to the embedded store's collections
+type memoryDocumentStore struct{ s *memoryStore }
+
+// memoryCollection one of the embedded store's keyed collections, items is its map,
+// either of documents or of whatever it holds, clashes says whether a document
+// would break the collection's unique fields
+type memoryCollection struct {
+	name    string
+	items   interface{}
+	clashes func(key string, doc obj) bool
+}
+
+// collections the keyed collections, the logs are a list and are kept apart
+func (m memoryDocumentStore) collections() []memoryCollection {
+	return []memoryCollection{
+		{"users", m.s.Users, m.userClashes},
+		{"writs", m.s.Writs, memoryWritStore{m.s}.clashes},
+		{"ratelimits", m.s.RateLimits, nil},
+		{"redirects", m.s.Redirects, nil},
+		{"series", m.s.Series, m.seriesClashes},
+		{"media", m.s.Media, nil},
+		{"comments", m.s.Comments, nil},
+		{"writ_revisions", m.s.Revisions, nil},
+	}
+}
+
+func (m memoryDocumentStore) collection(name string) (memoryCollection, bool) {
+	for _, c := range m.collections() {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return memoryCollection{}, false
+}
+
+// userClashes whether another user has the username or email, the lock must be held
+func (m memoryDocumentStore) userClashes(key string, doc obj) bool {
+	for other, existing := range m.s.Users {
+		if other == key {
+			continue
+		}
+		for _, field := range []string{"username", "email"} {
+			if doc[field] != nil && existing[field] == doc[field] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// seriesClashes whether another series has the title or slug, the lock must be held
+func (m memoryDocumentStore) seriesClashes(key string, doc obj) bool {
+	var series Series
+	if fromDoc(doc, &series) != nil {
+		return false
+	}
+	series.Key = key
+	return memorySeriesStore{m.s}.clashes(&series)
+}
+
+func (m memoryDocumentStore) Collections() []string {
+	names := []string{}
+	for _, c := range m.collections() {
+		names = append(names, c.name)
+	}
+	return append(names, "logs")
+}
+
+// itemDoc one of a collection's items as a document
+func itemDoc(key string, item interface{}) (obj, error) {
+	switch item := item.(type) {
+	case obj:
+		return item, nil
+	case ratelimit:
+		// by hand, so big starts don't pass through float64
+		return obj{"_key": key, "start": item.Start, "count": item.Count}, nil
+	}
+	return toDoc(item)
+}
+
+func (m memoryDocumentStore) Each(collection string, fn func(doc obj) error) error {
+	m.s.RLock()
+	defer m.s.RUnlock()
+
+	if collection == "logs" {
+		for _, entry := range m.s.Logs {
+			doc, err := toDoc(entry)
+			if err == nil {
+				err = fn(doc)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	c, ok := m.collection(collection)
+	if !ok {
+		return ErrNeedsArangoDB
+	}
+	items := reflect.ValueOf(c.items)
+	keys := make([]string, 0, items.Len())
+	for _, key := range items.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	// in order, so backups come out the same every time
+	sort.Strings(keys)
+	for _, key := range keys {
+		doc, err := itemDoc(key, items.MapIndex(reflect.ValueOf(key)).Interface())
+		if err == nil {
+			err = fn(doc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m memoryDocumentStore) Put(collection string, doc obj, overwrite bool) (bool, error) {
+	m.s.Lock()
+	defer m.s.Unlock()
+
+	if collection == "logs" {
+		var entry LogEntry
+		if err := fromDoc(doc, &entry); err != nil {
+			return false, err
+		}
+		m.s.Logs = append(m.s.Logs, entry)
+		if len(m.s.Logs) > MaxMemoryLogs {
+			m.s.Logs = m.s.Logs[len(m.s.Logs)-MaxMemoryLogs:]
+		}
+		m.s.dirty = true
+		return false, nil
+	}
+
+	c, ok := m.collection(collection)
+	if !ok {
+		return false, ErrNeedsArangoDB
+	}
+
+	// a copy, as the store keeps it, raw keeps big numbers exact
+	raw := doc
+	doc, err := toDoc(doc)
+	if err != nil {
+		return false, err
+	}
+	delete(doc, "_id")
+
+	key, _ := doc["_key"].(string)
+	if len(key) == 0 {
+		key = m.s.nextKey()
+		doc["_key"] = key
+	} else if n, err := strconv.ParseInt(key, 10, 64); err == nil && n > m.s.Seq {
+		// keep fresh keys from landing on restored ones
+		m.s.Seq = n
+	}
+	// revisions are the store's own business
+	delete(doc, "_rev")
+	if collection == "writs" {
+		doc["_rev"] = RandStr(11)
+	}
+
+	items := reflect.ValueOf(c.items)
+	exists := items.MapIndex(reflect.ValueOf(key)).IsValid()
+	if exists && !overwrite {
+		return false, ErrConflict
+	}
+	if c.clashes != nil && c.clashes(key, doc) {
+		return false, ErrConflict
+	}
+
+	item := reflect.ValueOf(doc)
+	if kind := items.Type().Elem(); kind != item.Type() {
+		// collections of structs get one decoded from the raw document
+		value := reflect.New(kind)
+		if err = fromDoc(raw, value.Interface()); err != nil {
+			return false, err
+		}
+		value.Elem().FieldByName("Key").SetString(key)
+		item = value.Elem()
+	}
+	items.SetMapIndex(reflect.ValueOf(key), item)
+	m.s.dirty = true
+	return exists, nil
+}
+
+func stringsContain(list []string, match string) bool {
+	for _, item := range list {
+		if item == match {
+			return true
+		}
+	}
+	return false
+}
+
+func rolesContain(list []Role, match Role) bool {
+	for _, item := range list {
+		if item == match {
+			return true
+		}
+	}
+	return false
+}
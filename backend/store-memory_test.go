@@ -0,0 +1,31 @@
+package backend
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentUserCreate(t *testing.T) {
+	setupTestStore(t)
+
+	var wg sync.WaitGroup
+	created := make(chan string, 8)
+	for i := 0; i < cap(created); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user := &User{Username: "racer", Email: "racer@example.com"}
+			if err := Stores.Users.Create(user); err == nil {
+				created <- user.Key
+			} else if err != ErrConflict {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(created)
+
+	if n := len(created); n != 1 {
+		t.Errorf("%d registrations of the same user went through, want 1", n)
+	}
+}
@@ -0,0 +1,216 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/arangodb/go-driver"
+)
+
+// UserStore is where users are kept
+type UserStore interface {
+	ByKey(key string) (User, error)
+	ByUsername(username string) (User, error)
+	ByEmail(email string) (User, error)
+	ByDetails(email, username string) (User, error)
+	Create(user *User) error
+	// Update merge changes into the user's document, nil values remove fields
+	Update(key string, changes obj) (User, error)
+	// Append add values to an array field of the user's document in one step,
+	// so changes made at the same time aren't lost, values it already has aren't added again
+	Append(key, field string, values ...interface{}) (User, error)
+	// RemoveValues take every occurrence of the values out of an array field of the user's document in one step
+	RemoveValues(key, field string, values ...interface{}) (User, error)
+	Subscribers() ([]User, error)
+}
+
+// WritStore is where writs are kept
+type WritStore interface {
+	Query(q *WritQuery) ([]Writ, error)
+	QueryOne(q *WritQuery) (Writ, error)
+	ByKey(key string) (Writ, error)
+	// Create store a new writ, setting its .Key, .Rev and .EditRev
+	Create(w *Writ) error
+	// Update merge changes into the writ's document, returning its new edit revision,
+	// when editrev isn't empty and doesn't match the stored one it fails with ErrStaleRevision;
+	// views, likes and notifications leave the edit revision be
+	Update(key, editrev string, changes obj) (string, error)
+	// ToggleLike like or unlike a writ for a user
+	ToggleLike(slug, userKey string) error
+	// ClaimNotification mark a writ as notified, only true for the first caller
+	ClaimNotification(key string) (bool, error)
+	Remove(key string) error
+}
+
+// LogStore is where request logs end up
+type LogStore interface {
+	Add(entries []LogEntry) error
+}
+
+// RateLimitStore keeps track of how often things are happening
+type RateLimitStore interface {
+	// Hit count an occurence, starting a new ratelimit at start if there isn't one yet
+	Hit(key string, start int64) (ratelimit, error)
+	// Penalize bump the count and push back the start of a ratelimit
+	Penalize(key string, start int64) error
+	Reset(key string) error
+}
+
+// RedirectStore remembers old paths, like the slugs a writ used to have, and which writ they lead to
+type RedirectStore interface {
+	// Add point a path at a writ, taking it over from whichever writ had it before
+	Add(path, writKey string) error
+	// Lookup the key of the writ a path leads to
+	Lookup(path string) (string, error)
+	// RemoveWrit forget every path leading to a writ
+	RemoveWrit(writKey string) error
+}
+
+// SeriesStore is where series of writs are kept
+type SeriesStore interface {
+	All() ([]Series, error)
+	ByKey(key string) (Series, error)
+	BySlug(slug string) (Series, error)
+	// Containing every series a writ is part of
+	Containing(writKey string) ([]Series, error)
+	// Create store a new series, setting its .Key, it fails with ErrConflict when the title or slug is taken
+	Create(s *Series) error
+	// Replace overwrite a stored series with s, it fails with ErrConflict when the title or slug is taken
+	Replace(s *Series) error
+	Remove(key string) error
+	// RemoveWrit take a writ out of every series it's in
+	RemoveWrit(writKey string) error
+}
+
+// MediaStore is where the details of uploaded files are kept
+type MediaStore interface {
+	// List the uploads whose name or mime type contains search, newest first,
+	// only those no writ links to when unused is true
+	List(search string, unused bool) ([]MediaFile, error)
+	ByKey(key string) (MediaFile, error)
+	// Create store a new upload under its key, it fails with ErrConflict when the key is taken
+	Create(m *MediaFile) error
+	Remove(key string) error
+	// SetWritRefs note that a writ links to these uploads and no others
+	SetWritRefs(writKey string, mediaKeys []string) error
+}
+
+// CommentStore is where comments on writs are kept
+type CommentStore interface {
+	ByKey(key string) (Comment, error)
+	// ByWrit a writ's comments, oldest first and without their markdown
+	ByWrit(writKey string) ([]Comment, error)
+	// Count how many comments each of the given writs has
+	Count(writKeys []string) (map[string]int64, error)
+	// Create store a new comment, setting its .Key
+	Create(c *Comment) error
+	// HasReplies whether any comment is a reply to this one
+	HasReplies(key string) (bool, error)
+	// Update merge changes into the comment's document
+	Update(key string, changes obj) error
+	Remove(key string) error
+	// RemoveWrit clear out all the comments on a writ
+	RemoveWrit(writKey string) error
+}
+
+// RevisionStore is where the prior versions of writs are kept
+type RevisionStore interface {
+	ByKey(key string) (WritRevision, error)
+	// ByWrit a writ's revisions, newest first and without their markdown
+	ByWrit(writKey string) ([]WritRevision, error)
+	// Create store a new revision, setting its .Key
+	Create(r *WritRevision) error
+	// RemoveWrit clear out the revision history of a writ
+	RemoveWrit(writKey string) error
+}
+
+// DocumentStore raw access to whole collections, backups go through it
+type DocumentStore interface {
+	// Collections the names of the collections the store holds
+	Collections() []string
+	// Each call fn with every document in a collection, stopping at the first error
+	Each(collection string, fn func(doc obj) error) error
+	// Put store a document as is, keeping its _key, when the key is taken it's replaced
+	// if overwrite is true, otherwise or if it clashes with another document it fails with ErrConflict;
+	// it reports whether an existing document was replaced
+	Put(collection string, doc obj, overwrite bool) (bool, error)
+}
+
+// Stores all the storage backends the app goes through
+var Stores struct {
+	Users      UserStore
+	Writs      WritStore
+	Logs       LogStore
+	RateLimits RateLimitStore
+	Redirects  RedirectStore
+	Series     SeriesStore
+	Media      MediaStore
+	Comments   CommentStore
+	Revisions  RevisionStore
+	Documents  DocumentStore
+}
+
+var (
+	// ErrNotFound the store doesn't have what was asked for
+	ErrNotFound = errors.New("not found in the store")
+	// ErrStaleRevision an update was made against an outdated revision
+	ErrStaleRevision = errors.New("the stored revision has changed since")
+	// ErrConflict a document with the same unique fields is already stored
+	ErrConflict = errors.New("a conflicting document is already stored")
+	// ErrNeedsArangoDB the feature isn't available with the embedded store
+	ErrNeedsArangoDB = errors.New("this only works when the app is backed by arangodb")
+)
+
+// isNotFound check if err means a store/db came up empty handed
+func isNotFound(err error) bool {
+	return err == ErrNotFound || driver.IsNotFound(err) || driver.IsNoMoreDocuments(err)
+}
+
+// usingArango is the app backed by arangodb, as opposed to the embedded store
+func usingArango() bool {
+	return DB != nil
+}
+
+// setupStorage connect to whichever storage backend the config asks for
+func setupStorage() error {
+	if Conf.Storage == "memory" || Conf.Storage == "embedded" {
+		location := Conf.StorageFile
+		if len(location) == 0 {
+			location = Conf.Private + "/store.json"
+		}
+		fmt.Println("Using the embedded store at ", location)
+		return setupMemoryStore(location)
+	}
+
+	dbobj := Conf.Raw["db"].(obj)
+
+	addrs := interfaceSliceToStringSlice(dbobj["local_address"].([]interface{}))
+
+	dbname := dbobj["name"].(string)
+	dbusername := dbobj["username"].(string)
+	dbpassword := dbobj["password"].(string)
+
+	err := setupDB(addrs, dbname, dbusername, dbpassword)
+	if err != nil {
+		fmt.Println("couldn't connect to DB locally, trying remote connection now...")
+
+		addrs = interfaceSliceToStringSlice(dbobj["address"].([]interface{}))
+
+		err = setupDB(addrs, dbname, dbusername, dbpassword)
+		if err != nil {
+			return err
+		}
+	}
+
+	Stores.Users = arangoUserStore{}
+	Stores.Writs = arangoWritStore{}
+	Stores.Logs = arangoLogStore{}
+	Stores.RateLimits = arangoRateLimitStore{}
+	Stores.Redirects = arangoRedirectStore{}
+	Stores.Series = arangoSeriesStore{}
+	Stores.Media = arangoMediaStore{}
+	Stores.Comments = arangoCommentStore{}
+	Stores.Revisions = arangoRevisionStore{}
+	Stores.Documents = arangoDocumentStore{}
+	return nil
+}
@@ -138,6 +138,7 @@ func StaticResponse(code int, msg string) *CodedResponse {
 func MakeCodedResponse(code int, msg string, primitive interface{}) *CodedResponse {
 	cr := &CodedResponse{
 		Code: code,
+		Msg:  msg,
 	}
 	res, err := msgpack.Marshal(primitive)
 	if err != nil {
@@ -0,0 +1,462 @@
+package backend
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// A lossless (VP8L) webp encoder, the standard library can't write webp.
+// It subtracts green, predicts each pixel from its neighbours with the best of the
+// fourteen predictors for every 16x16 block, then prefix codes the residuals.
+// See https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+
+// webpMaxSize the widest or tallest image webp can hold
+const webpMaxSize = 1 << 14
+
+const (
+	webpPredictorBits  = 4
+	webpMaxCodeLength  = 15
+	webpMaxCLCodeBits  = 7
+	webpGreenAlphabet  = 256 + 24
+	webpDistAlphabet   = 40
+	webpPredictorModes = 14
+)
+
+// the order code length code lengths are written in
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpBits packs bits least significant first
+type webpBits struct {
+	buf []byte
+	acc uint64
+	n   uint
+}
+
+func (b *webpBits) write(value uint32, bits uint) {
+	b.acc |= uint64(value) << b.n
+	b.n += bits
+	for b.n >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.n -= 8
+	}
+}
+
+func (b *webpBits) flush() []byte {
+	if b.n > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.n = 0, 0
+	}
+	return b.buf
+}
+
+// webpCode a prefix code, the codes are bit reversed ready to be written
+type webpCode struct {
+	lengths []uint8
+	codes   []uint32
+}
+
+func (c *webpCode) write(b *webpBits, symbol int) {
+	b.write(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+// huffmanLengths code lengths for symbols occurring counts times, none longer than limit;
+// a lone symbol gets a partner so the code is always complete
+func huffmanLengths(counts []int, limit int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	used := []int{}
+	for symbol, count := range counts {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return lengths
+	case 1:
+		partner := 0
+		if used[0] == 0 {
+			partner = 1
+		}
+		lengths[used[0]], lengths[partner] = 1, 1
+		return lengths
+	}
+
+	weights := make([]int, len(used))
+	for i, symbol := range used {
+		weights[i] = counts[symbol]
+	}
+
+	type node struct {
+		weight, left, right int
+	}
+	for {
+		nodes := make([]node, 0, 2*len(used))
+		for _, w := range weights {
+			nodes = append(nodes, node{w, -1, -1})
+		}
+		queue := make([]int, len(used))
+		for i := range queue {
+			queue[i] = i
+		}
+		for len(queue) > 1 {
+			sort.SliceStable(queue, func(i, j int) bool { return nodes[queue[i]].weight < nodes[queue[j]].weight })
+			a, b := queue[0], queue[1]
+			nodes = append(nodes, node{nodes[a].weight + nodes[b].weight, a, b})
+			queue = append(queue[2:], len(nodes)-1)
+		}
+
+		depths := make([]int, len(nodes))
+		deepest := 0
+		for i := len(nodes) - 1; i >= len(used); i-- {
+			n := nodes[i]
+			depths[n.left] = depths[i] + 1
+			depths[n.right] = depths[i] + 1
+		}
+		for i := range used {
+			if depths[i] > deepest {
+				deepest = depths[i]
+			}
+		}
+		if deepest <= limit {
+			for i, symbol := range used {
+				lengths[symbol] = uint8(depths[i])
+			}
+			return lengths
+		}
+
+		// too deep, flatten the counts out and try again
+		for i := range weights {
+			weights[i] = (weights[i] + 1) / 2
+		}
+	}
+}
+
+// makeWebpCode the canonical prefix code for a set of code lengths
+func makeWebpCode(lengths []uint8) *webpCode {
+	var count [webpMaxCodeLength + 1]uint32
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	var next [webpMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for bits := 1; bits <= webpMaxCodeLength; bits++ {
+		code = (code + count[bits-1]) << 1
+		next[bits] = code
+	}
+
+	c := &webpCode{lengths: lengths, codes: make([]uint32, len(lengths))}
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		code := next[l]
+		next[l]++
+		reversed := uint32(0)
+		for i := uint8(0); i < l; i++ {
+			reversed = reversed<<1 | (code>>i)&1
+		}
+		c.codes[symbol] = reversed
+	}
+	return c
+}
+
+// writeWebpCode write the prefix code for a histogram, using the short form when
+// there are only one or two small symbols
+func writeWebpCode(b *webpBits, counts []int) *webpCode {
+	used := []int{}
+	for symbol, count := range counts {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = append(used, 0)
+		}
+		b.write(1, 1)
+		b.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			b.write(0, 1)
+			b.write(uint32(used[0]), 1)
+		} else {
+			b.write(1, 1)
+			b.write(uint32(used[0]), 8)
+		}
+		lengths := make([]uint8, len(counts))
+		if len(used) == 2 {
+			b.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return makeWebpCode(lengths)
+	}
+
+	lengths := huffmanLengths(counts, webpMaxCodeLength)
+	code := makeWebpCode(lengths)
+
+	// the code lengths are written with a prefix code of their own
+	clCounts := make([]int, 19)
+	for _, l := range lengths {
+		clCounts[l]++
+	}
+	clLengths := huffmanLengths(clCounts, webpMaxCLCodeBits)
+	clCode := makeWebpCode(clLengths)
+
+	n := len(webpCodeLengthOrder)
+	for n > 4 && clLengths[webpCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	b.write(0, 1)
+	b.write(uint32(n-4), 4)
+	for _, symbol := range webpCodeLengthOrder[:n] {
+		b.write(uint32(clLengths[symbol]), 3)
+	}
+	// every symbol's length follows
+	b.write(0, 1)
+	for _, l := range lengths {
+		clCode.write(b, int(l))
+	}
+	return code
+}
+
+// writeWebpPixels prefix code a run of argb pixels, each as plain literals
+func writeWebpPixels(b *webpBits, pixels []uint32) {
+	green := make([]int, webpGreenAlphabet)
+	red := make([]int, 256)
+	blue := make([]int, 256)
+	alpha := make([]int, 256)
+	for _, p := range pixels {
+		green[p>>8&0xff]++
+		red[p>>16&0xff]++
+		blue[p&0xff]++
+		alpha[p>>24]++
+	}
+
+	greenCode := writeWebpCode(b, green)
+	redCode := writeWebpCode(b, red)
+	blueCode := writeWebpCode(b, blue)
+	alphaCode := writeWebpCode(b, alpha)
+	writeWebpCode(b, make([]int, webpDistAlphabet))
+
+	for _, p := range pixels {
+		greenCode.write(b, int(p>>8&0xff))
+		redCode.write(b, int(p>>16&0xff))
+		blueCode.write(b, int(p&0xff))
+		alphaCode.write(b, int(p>>24))
+	}
+}
+
+func webpAverage2(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+// webpChannels apply f to each channel of some pixels
+func webpChannels(f func(a, b, c int) int, a, b, c uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := f(int(a>>shift&0xff), int(b>>shift&0xff), int(c>>shift&0xff))
+		if v < 0 {
+			v = 0
+		} else if v > 255 {
+			v = 255
+		}
+		out |= uint32(v) << shift
+	}
+	return out
+}
+
+func webpAbs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func webpSelect(l, t, tl uint32) uint32 {
+	pl, pt := 0, 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		cl, ct, ctl := int(l>>shift&0xff), int(t>>shift&0xff), int(tl>>shift&0xff)
+		estimate := cl + ct - ctl
+		pl += webpAbs(estimate - cl)
+		pt += webpAbs(estimate - ct)
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+// webpPredict what a predictor mode guesses the pixel at i is, from the ones before it
+func webpPredict(mode int, px []uint32, i, width int) uint32 {
+	l, t, tl, tr := px[i-1], px[i-width], px[i-width-1], px[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return webpAverage2(webpAverage2(l, tr), t)
+	case 6:
+		return webpAverage2(l, tl)
+	case 7:
+		return webpAverage2(l, t)
+	case 8:
+		return webpAverage2(tl, t)
+	case 9:
+		return webpAverage2(t, tr)
+	case 10:
+		return webpAverage2(webpAverage2(l, tl), webpAverage2(t, tr))
+	case 11:
+		return webpSelect(l, t, tl)
+	case 12:
+		return webpChannels(func(a, b, c int) int { return a + b - c }, l, t, tl)
+	}
+	return webpChannels(func(a, b, _ int) int { return a + (a-b)/2 }, webpAverage2(l, t), tl, 0)
+}
+
+// webpResidual the difference between a pixel and its prediction, channel by channel
+func webpResidual(p, predicted uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		out |= ((p>>shift - predicted>>shift) & 0xff) << shift
+	}
+	return out
+}
+
+// webpResidualCost roughly how many bits a residual takes, small ones code shorter
+func webpResidualCost(r uint32) int {
+	cost := 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		cost += webpAbs(int(int8(r >> shift)))
+	}
+	return cost
+}
+
+// encodeWebP write an image out as a lossless webp
+func encodeWebP(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > webpMaxSize || height > webpMaxSize {
+		return ErrImageTooBig
+	}
+
+	px := make([]uint32, width*height)
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+			// subtract green
+			r, g, b := c.R-c.G, c.G, c.B-c.G
+			px[y*width+x] = uint32(c.A)<<24 | uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+		}
+	}
+
+	// pick the predictor that leaves the smallest residuals in each block
+	blockSize := 1 << webpPredictorBits
+	blocksWide := (width + blockSize - 1) / blockSize
+	blocksHigh := (height + blockSize - 1) / blockSize
+	modes := make([]uint32, blocksWide*blocksHigh)
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			best, bestCost := 0, -1
+			for mode := 0; mode < webpPredictorModes; mode++ {
+				cost := 0
+				for y := by * blockSize; y < (by+1)*blockSize && y < height; y++ {
+					if y == 0 {
+						continue
+					}
+					for x := bx * blockSize; x < (bx+1)*blockSize && x < width; x++ {
+						if x == 0 {
+							continue
+						}
+						i := y*width + x
+						cost += webpResidualCost(webpResidual(px[i], webpPredict(mode, px, i, width)))
+					}
+				}
+				if bestCost == -1 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[by*blocksWide+bx] = 0xff000000 | uint32(best)<<8
+		}
+	}
+
+	residuals := make([]uint32, len(px))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			var predicted uint32
+			switch {
+			case x == 0 && y == 0:
+				predicted = 0xff000000
+			case y == 0:
+				predicted = px[i-1]
+			case x == 0:
+				predicted = px[i-width]
+			default:
+				mode := int(modes[(y>>webpPredictorBits)*blocksWide+x>>webpPredictorBits] >> 8 & 0xff)
+				predicted = webpPredict(mode, px, i, width)
+			}
+			residuals[i] = webpResidual(px[i], predicted)
+		}
+	}
+
+	b := &webpBits{buf: []byte{0x2f}}
+	b.write(uint32(width-1), 14)
+	b.write(uint32(height-1), 14)
+	if hasAlpha {
+		b.write(1, 1)
+	} else {
+		b.write(0, 1)
+	}
+	b.write(0, 3)
+
+	// subtract green transform
+	b.write(1, 1)
+	b.write(2, 2)
+	// predictor transform, with the modes as a little image of their own
+	b.write(1, 1)
+	b.write(0, 2)
+	b.write(webpPredictorBits-2, 3)
+	b.write(0, 1)
+	writeWebpPixels(b, modes)
+	// no more transforms
+	b.write(0, 1)
+
+	// no color cache, one set of prefix codes for the whole image
+	b.write(0, 1)
+	b.write(0, 1)
+	writeWebpPixels(b, residuals)
+
+	data := b.flush()
+	chunk := len(data)
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunk))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
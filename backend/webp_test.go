@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testImage an image with smooth runs, repeats and noise in it, so every
+// part of the encoder gets used, with the alpha varied when it's not opaque
+func testImage(width, height int, opaque bool) *image.NRGBA {
+	rnd := rand.New(rand.NewSource(int64(width*height + 1)))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{uint8(x * 7), uint8(y * 5), uint8(x ^ y), 0xff}
+			switch {
+			case x%11 == 3:
+				c = color.NRGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 0xff}
+			case y%4 == 2:
+				c = color.NRGBA{0x20, 0x40, 0x80, 0xff}
+			}
+			if !opaque {
+				c.A = uint8(rnd.Intn(256))
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	cases := []struct {
+		name          string
+		width, height int
+		opaque        bool
+	}{
+		{"1x1", 1, 1, true},
+		{"1x1 alpha", 1, 1, false},
+		{"opaque", 64, 48, true},
+		{"alpha", 64, 48, false},
+		{"odd opaque", 37, 23, true},
+		{"odd alpha", 13, 101, false},
+		{"one row", 129, 1, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			want := testImage(tc.width, tc.height, tc.opaque)
+			var buf bytes.Buffer
+			if err := encodeWebP(&buf, want); err != nil {
+				t.Fatal(err)
+			}
+			got, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("x/image can't decode it: %v", err)
+			}
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("decoded to %v, want %v", got.Bounds(), want.Bounds())
+			}
+			for y := 0; y < tc.height; y++ {
+				for x := 0; x < tc.width; x++ {
+					w := want.NRGBAAt(x, y)
+					g := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+					if w.A == 0 {
+						// the colour of an invisible pixel doesn't matter
+						w, g = color.NRGBA{}, color.NRGBA{A: g.A}
+					}
+					if g != w {
+						t.Fatalf("pixel %d,%d is %v, want %v", x, y, g, w)
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,9 @@
 package backend
 
 import (
-	"context"
 	"fmt"
+	"html"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -13,16 +14,24 @@ import (
 // Writ - struct representing a post or document in the database
 type Writ struct {
 	Key         string      `json:"_key,omitempty" msgpack:"_key,omitempty"`
+	Rev         string      `json:"_rev,omitempty" msgpack:"_rev,omitempty"`
+	EditRev     string      `json:"editrev,omitempty" msgpack:"editrev,omitempty"`
 	Type        string      `json:"type,omitempty" msgpack:"type,omitempty"`
 	Title       string      `json:"title,omitempty" msgpack:"title,omitempty"`
 	AuthorKey   string      `json:"authorkey,omitempty" msgpack:"authorkey,omitempty"`
 	Author      string      `json:"author,omitempty" msgpack:"author,omitempty"`
+	Editor      string      `json:"editor,omitempty" msgpack:"editor,omitempty"`
 	Content     string      `json:"content,omitempty" msgpack:"content,omitempty"`
 	Injection   string      `json:"injection,omitempty" msgpack:"injection,omitempty"`
 	Markdown    string      `json:"markdown,omitempty" msgpack:"markdown,omitempty"`
 	Description string      `json:"description,omitempty" msgpack:"description,omitempty"`
 	Slug        string      `json:"slug,omitempty" msgpack:"slug,omitempty"`
 	Tags        []string    `json:"tags,omitempty" msgpack:"tags,omitempty"`
+	Aliases     []string    `json:"aliases,omitempty" msgpack:"aliases,omitempty"`
+	TOC         []TOCEntry  `json:"toc,omitempty" msgpack:"toc,omitempty"`
+	WordCount   int         `json:"wordcount,omitempty" msgpack:"wordcount,omitempty"`
+	ReadingTime int         `json:"readingtime,omitempty" msgpack:"readingtime,omitempty"`
+	Excerpt     string      `json:"excerpt,omitempty" msgpack:"excerpt,omitempty"`
 	Edits       []time.Time `json:"edits,omitempty" msgpack:"edits,omitempty"`
 	Created     time.Time   `json:"created,omitempty" msgpack:"created,omitempty"`
 	Views       int64       `json:"views,omitempty" msgpack:"views,omitempty"`
@@ -32,6 +41,26 @@ type Writ struct {
 	MembersOnly bool        `json:"membersonly,omitempty" msgpack:"membersonly,omitempty"`
 	NoComments  bool        `json:"nocomments,omitempty" msgpack:"nocomments,omitempty"`
 	Roles       []int64     `json:"roles,omitempty" msgpack:"roles,omitempty"`
+	State       string      `json:"state,omitempty" msgpack:"state,omitempty"`
+	PublishAt   time.Time   `json:"publishat,omitempty" msgpack:"publishat,omitempty"`
+	Notified    bool        `json:"notified,omitempty" msgpack:"notified,omitempty"`
+
+	Snippet      string     `json:"snippet,omitempty" msgpack:"snippet,omitempty"`
+	CommentCount int64      `json:"commentcount,omitempty" msgpack:"commentcount,omitempty"`
+	Comments     []*Comment `json:"comments,omitempty" msgpack:"comments,omitempty"`
+}
+
+// Summary the writ's description, or when it hasn't got one the excerpt from its content
+func (w *Writ) Summary() string {
+	if len(w.Description) != 0 {
+		return w.Description
+	}
+	if len(w.Excerpt) == 0 && len(w.Content) != 0 {
+		// rendered before excerpts were, it'll have one once it's saved again
+		_, _, excerpt := readingStats(w.Content)
+		return excerpt
+	}
+	return w.Excerpt
 }
 
 // GetLink get a slug link with a key query param incase the title/slug changed
@@ -65,19 +94,29 @@ type WritQuery struct {
 	Title              string                 `json:"title,omitempty" msgpack:"title,omitempty"`
 	Slug               string                 `json:"slug,omitempty" msgpack:"slug,omitempty"`
 	Author             string                 `json:"author,omitempty" msgpack:"author,omitempty"`
+	State              string                 `json:"state,omitempty" msgpack:"state,omitempty"`
+	Search             string                 `json:"search,omitempty" msgpack:"search,omitempty"`
 	Created            time.Time              `json:"created,omitempty" msgpack:"created,omitempty"`
 	Between            Timeframe              `json:"between,omitempty" msgpack:"between,omitempty"`
 	Roles              []int64                `json:"roles,omitempty" msgpack:"roles,omitempty"`
 	Limit              []int64                `json:"limit,omitempty" msgpack:"limit,omitempty"`
 	Tags               []string               `json:"tags,omitempty" msgpack:"tags,omitempty"`
+	Series             string                 `json:"series,omitempty" msgpack:"series,omitempty"`
 	Omissions          []string               `json:"omissions,omitempty" msgpack:"omissions,omitempty"`
+
+	// restricted queries only return what viewer is allowed to read, see RestrictTo
+	restricted bool
+	viewer     *User
+	// seriesWrits the writs in .Series, in order, see prepSeries
+	seriesWrits []string
+	// relatedTo only other writs sharing a tag or someone who liked them with this one, see computeRelated
+	relatedTo *Writ
 }
 
 // Exec execute a WritQuery to retrieve some/certain writs
 func (q *WritQuery) Exec() ([]Writ, error) {
-	writs := []Writ{}
-
 	if q.One {
+		writs := []Writ{}
 		writ, err := q.ExecOne()
 		if err == nil {
 			writs = append(writs, writ)
@@ -85,312 +124,65 @@ func (q *WritQuery) Exec() ([]Writ, error) {
 		return writs, err
 	}
 
-	if q.Vars == nil {
-		q.Vars = obj{}
-	}
-
-	query := "FOR writ IN writs "
-
-	filter := ""
-	firstfilter := true
-
-	if q.PrivateOnly {
-		if !firstfilter {
-			filter += "&& "
-		} else {
-			firstfilter = false
-		}
-		filter += `writ.public == false `
-	} else if !q.IncludePrivate {
-		if !firstfilter {
-			filter += "&& "
-		} else {
-			firstfilter = false
-		}
-		filter += `writ.public == true `
-	}
-
-	if q.MembersOnly {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		filter += `writ.membersonly == true `
-	} else if !q.IncludeMembersOnly {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		filter += `writ.membersonly == false `
-	}
-
-	startzero := q.Between.Start.IsZero()
-	endzero := q.Between.End.IsZero()
-	if !startzero || !endzero {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		if !startzero && !endzero {
-			q.Vars["@betweenStart"] = q.Between.Start
-			q.Vars["@betweenEnd"] = q.Between.End
-			filter += "writ.created > @betweenStart && writ.created < @betweenEnd "
-		} else if !startzero {
-			q.Vars["@betweenStart"] = q.Between.Start
-			filter += "writ.created > @betweenStart "
-		} else if !endzero {
-			q.Vars["@betweenEnd"] = q.Between.Start
-			filter += "writ.created < @betweenEnd "
-		}
-	}
-
-	if len(q.Author) > 0 {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["author"] = q.Author
-		filter += `writ.author == @author `
+	q.prepOmissions()
+	if err := q.prepSeries(); err != nil {
+		return []Writ{}, err
 	}
 
-	if len(q.ViewedBy) > 0 {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["viewedby"] = q.ViewedBy
-		filter += `@viewedby IN writ.viewedby `
+	writs, err := Stores.Writs.Query(q)
+	if err != nil {
+		return writs, err
 	}
 
-	if len(q.LikedBy) > 0 {
-		if !firstfilter {
-			filter += "&& "
+	if len(q.Search) > 0 {
+		terms := searchTerms(q.Search)
+		for i := range writs {
+			highlightWrit(&writs[i], terms)
 		}
-		firstfilter = false
-		q.Vars["likedby"] = q.LikedBy
-		filter += `@likedby IN writ.likedby `
 	}
 
-	if len(q.Roles) > 0 {
-		if !firstfilter {
-			filter += "&& "
+	if q.Comments && len(writs) > 0 {
+		keys := make([]string, len(writs))
+		for i, writ := range writs {
+			keys[i] = writ.Key
 		}
-		firstfilter = false
-		q.Vars["roles"] = q.Roles
-		filter += `@roles ALL IN writ.roles `
-	}
-
-	if len(q.Tags) > 0 {
-		if !firstfilter {
-			filter += "&& "
+		counts, err := CountComments(keys)
+		if err != nil && DevMode {
+			fmt.Println("DB Multiple Query - couldn't count comments: ", err)
 		}
-		firstfilter = false
-		q.Vars["tags"] = q.Tags
-		filter += `@tags ALL IN writ.tags `
-	}
-
-	if !firstfilter {
-		query += "FILTER " + filter
-	}
-
-	if !q.DontSort {
-		query += "SORT writ.created DESC "
-	}
-
-	if len(q.Limit) > 0 {
-		q.Vars["pagenum"] = q.Limit[0]
-		query += `LIMIT @pagenum`
-		if len(q.Limit) == 2 {
-			q.Vars["pagesize"] = q.Limit[1]
-			query += `, @pagesize `
+		for i := range writs {
+			writs[i].CommentCount = counts[writs[i].Key]
 		}
 	}
 
-	query += " RETURN "
-
-	if !q.EditorMode {
-		q.Omissions = append(q.Omissions, "markdown", "edits", "public", "roles", "authorkey")
-	} else {
-		q.Omissions = append(q.Omissions, "content")
-	}
-
-	if !q.Extensive {
-		q.Omissions = append(q.Omissions, "likedby", "viewedby")
-	}
-
-	if len(q.Omissions) > 0 {
-		q.Vars["omissions"] = q.Omissions
-		query += "UNSET(writ, @omissions)"
-	} else {
-		query += "writ"
-	}
-
-	if DevMode {
-		fmt.Println("\n You're trying this query now: \n", query, "\n\t")
-	}
-
-	ctx := driver.WithQueryCount(context.Background())
-	cursor, err := DB.Query(ctx, query, q.Vars)
-	if err == nil {
-		defer cursor.Close()
-		for {
-			var writ Writ
-			_, err := cursor.ReadDocument(ctx, &writ)
-			if driver.IsNoMoreDocuments(err) {
-				break
-			} else if err != nil {
-				if DevMode {
-					fmt.Println("DB Multiple Query - something strange happened: ", err)
-				}
-				panic(err)
-			}
-			writs = append(writs, writ)
-		}
-	} else if driver.IsNoMoreDocuments(err) {
-		fmt.Println(`No more docs? Awww :( - `, err)
-	} else if DevMode {
-		fmt.Println("\n... And, it would seem that it has failed: \n", err, "\n\t")
-	}
-	return writs, err
+	return writs, nil
 }
 
 // ExecOne execute a WritQuery to retrieve a single writ
 func (q *WritQuery) ExecOne() (Writ, error) {
-	var writ Writ
-
-	if q.Vars == nil {
-		q.Vars = obj{}
+	q.prepOmissions()
+	if err := q.prepSeries(); err != nil {
+		return Writ{}, err
 	}
 
-	query := "FOR writ IN writs "
-
-	filter := ""
-	firstfilter := true
-
-	if q.PrivateOnly {
-		if !firstfilter {
-			filter += "&& "
-		} else {
-			firstfilter = false
-		}
-		filter += `writ.public == false `
-	} else if !q.IncludePrivate {
-		if !firstfilter {
-			filter += "&& "
-		} else {
-			firstfilter = false
-		}
-		filter += `writ.public == true `
-	}
-
-	if q.MembersOnly {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		filter += `writ.membersonly == true `
-	}
-
-	if !q.Created.IsZero() {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["@created"] = q.Created
-		filter += "writ.created == @created "
-	}
-
-	if len(q.Key) > 0 {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["key"] = q.Key
-		filter += `writ._key == @key `
-	}
-
-	if len(q.Slug) > 0 {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["slug"] = q.Slug
-		filter += `writ.slug == @slug `
-	}
+	writ, err := Stores.Writs.QueryOne(q)
 
-	if len(q.Title) > 0 {
-		if !firstfilter {
-			filter += "&& "
+	if err == nil && q.Comments && !writ.NoComments {
+		var cerr error
+		writ.Comments, writ.CommentCount, cerr = CommentTree(writ.Key)
+		if cerr != nil && DevMode {
+			fmt.Println("ExecOne - couldn't get the writ's comments: ", cerr)
 		}
-		firstfilter = false
-		q.Vars["title"] = q.Title
-		filter += `writ.title == @title `
 	}
 
-	if len(q.Author) > 0 {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["author"] = q.Author
-		filter += `writ.author == @author `
-	}
-
-	if len(q.ViewedBy) > 0 {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["viewedby"] = q.ViewedBy
-		filter += `@viewedby IN writ.viewedby `
-	}
-
-	if len(q.LikedBy) > 0 {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["likedby"] = q.LikedBy
-		filter += `@likedby IN writ.likedby `
-	}
-
-	if len(q.Roles) > 0 {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["roles"] = q.Roles
-		filter += `@roles ALL IN writ.roles `
-	}
-
-	if len(q.Tags) > 0 {
-		if !firstfilter {
-			filter += "&& "
-		}
-		firstfilter = false
-		q.Vars["tags"] = q.Tags
-		filter += `@tags ALL IN writ.tags `
-	}
-
-	if !firstfilter {
-		query += "FILTER " + filter
-	}
-
-	if q.UpdateViews {
-		query += "UPDATE writ WITH {"
-		if len(q.Viewer) > 0 {
-			q.Vars["viewer"] = q.Viewer
-			query += "viewedby: PUSH(writ.viewedby, @viewer, true)"
-		} else {
-			query += "views: writ.views + 1"
-		}
-		query += "} IN writs "
-	}
-
-	query += "RETURN "
+	return writ, err
+}
 
+// prepOmissions add the fields a query shouldn't return to .Omissions
+// which depends on whether it's for the editor or the public
+func (q *WritQuery) prepOmissions() {
 	if !q.EditorMode {
-		q.Omissions = append(q.Omissions, "markdown", "edits", "public", "roles", "authorkey")
+		q.Omissions = append(q.Omissions, "_rev", "editrev", "markdown", "public", "roles", "authorkey", "state", "publishat", "notified")
 	} else {
 		q.Omissions = append(q.Omissions, "content")
 	}
@@ -398,25 +190,6 @@ func (q *WritQuery) ExecOne() (Writ, error) {
 	if !q.Extensive {
 		q.Omissions = append(q.Omissions, "likedby", "viewedby")
 	}
-
-	if len(q.Omissions) > 0 {
-		q.Vars["omissions"] = q.Omissions
-		query += "UNSET(writ, @omissions)"
-	} else {
-		query += "writ"
-	}
-
-	if DevMode {
-		fmt.Println("\n You're trying this query now: \n", query, "\n\t")
-	}
-
-	err := QueryOne(query, q.Vars, &writ)
-
-	if DevMode && err != nil {
-		fmt.Println("\n... And, it would seem that it has failed: \n", err, "\n\t")
-	}
-
-	return writ, err
 }
 
 // Slugify generate and set .Slug from .Title
@@ -424,9 +197,15 @@ func (w *Writ) Slugify() {
 	w.Slug = slugify.Slugify(w.Title)
 }
 
-// RenderContent from .Markdown generate html and set .Content
+// RenderContent from .Markdown generate html and set .Content and .TOC,
+// extensions hook into the pipeline in markdown.go rather than in here,
+// the html is sanitized with the policy for the writ's author;
+// the word count, reading time (in minutes) and excerpt are worked out from it too
 func (w *Writ) RenderContent() {
-	w.Content = string(renderMarkdown([]byte(w.Markdown), false)[:])
+	doc := RenderWritMarkdown(w)
+	w.Content = string(contentPolicy(w.Author).SanitizeBytes(doc.HTML))
+	w.TOC = doc.TOC
+	w.WordCount, w.ReadingTime, w.Excerpt = readingStats(w.Content)
 }
 
 // ToObj convert writ into map[string]interface{}
@@ -448,8 +227,16 @@ func (w *Writ) ToObj(omissions ...string) obj {
 	if len(w.Author) != 0 {
 		output["author"] = w.Author
 	}
+	if len(w.Editor) != 0 {
+		output["editor"] = w.Editor
+	}
 	if len(w.Content) != 0 {
 		output["content"] = w.Content
+		// the table of contents and reading stats go with the content, even when they're empty now
+		output["toc"] = w.TOC
+		output["wordcount"] = w.WordCount
+		output["readingtime"] = w.ReadingTime
+		output["excerpt"] = w.Excerpt
 	}
 	if len(w.Injection) != 0 {
 		output["injection"] = w.Injection
@@ -466,6 +253,9 @@ func (w *Writ) ToObj(omissions ...string) obj {
 	if len(w.Tags) != 0 {
 		output["tags"] = w.Tags
 	}
+	if len(w.Aliases) != 0 {
+		output["aliases"] = w.Aliases
+	}
 	if len(w.Edits) != 0 {
 		output["edits"] = w.Edits
 	}
@@ -493,6 +283,12 @@ func (w *Writ) ToObj(omissions ...string) obj {
 	if len(w.Roles) != 0 {
 		output["roles"] = w.Roles
 	}
+	if len(w.State) != 0 {
+		output["state"] = w.State
+	}
+	if !w.PublishAt.IsZero() {
+		output["publishat"] = w.PublishAt
+	}
 
 	if len(omissions) != 0 {
 		for _, omission := range omissions {
@@ -503,27 +299,22 @@ func (w *Writ) ToObj(omissions ...string) obj {
 	return output
 }
 
-// Update update a writ's details using a map[string]interface{}
-func (w *Writ) Update(query string, vars obj) error {
-	if len(w.Key) < 0 {
+// Update update a writ's details using a map[string]interface{}, nil values remove fields
+func (w *Writ) Update(changes obj) error {
+	if len(w.Key) < 1 {
 		return ErrIncompleteWrit
 	}
-	vars["key"] = w.Key
-	query = "FOR w in writs FILTER w._key == @key UPDATE w WITH " + query + " IN writs OPTIONS {keepNull: false, waitForSync: true} RETURN NEW"
-	ctx := driver.WithQueryCount(context.Background())
-	cursor, err := DB.Query(ctx, query, vars)
-	defer cursor.Close()
+	editrev, err := Stores.Writs.Update(w.Key, "", changes)
 	if err == nil {
-		_, err = cursor.ReadDocument(ctx, w)
+		*w, err = WritByKey(w.Key)
+		w.EditRev = editrev
 	}
 	return err
 }
 
 // WritByKey retrieve user using their db document key
 func WritByKey(key string) (Writ, error) {
-	var writ Writ
-	_, err := Writs.ReadDocument(context.Background(), key, &writ)
-	return writ, err
+	return Stores.Writs.ByKey(key)
 }
 
 // InitWrit initialize a new writ
@@ -532,7 +323,18 @@ func InitWrit(w *Writ) error {
 		return ErrMissingTags
 	}
 
-	ctx := driver.WithWaitForSync(context.Background(), true)
+	w.Comments = nil
+	w.CommentCount = 0
+	w.Snippet = ""
+	w.Notified = false
+
+	if len(w.State) != 0 && !validWritState(w.State) {
+		return ErrInvalidWritState
+	}
+
+	if err := validateInjection(w.Injection); err != nil {
+		return err
+	}
 
 	exists := true
 	var err error
@@ -550,7 +352,9 @@ func InitWrit(w *Writ) error {
 	}
 
 	if !exists {
-		w.Created = time.Now()
+		if w.Created.IsZero() {
+			w.Created = time.Now()
+		}
 		if len(w.Markdown) < 1 || len(w.Title) < 1 || len(w.Author) < 1 {
 			if DevMode {
 				fmt.Println("InitWrit - it's horribly incomplete, fix it, add in author, title, and markdown")
@@ -566,23 +370,56 @@ func InitWrit(w *Writ) error {
 			return ErrAuthorIsNoUser
 		}
 		w.AuthorKey = user.Key
+		if len(w.Editor) == 0 {
+			w.Editor = w.Author
+		}
+
+		if err = w.syncState(); err != nil {
+			return err
+		}
 
 		w.RenderContent()
 		if len(w.Slug) < 1 {
 			w.Slugify()
 		}
 
-		meta, err := Writs.CreateDocument(ctx, w)
+		err = Stores.Writs.Create(w)
 		if err != nil {
 			if DevMode {
 				fmt.Println(`InitWrit - creating a writ in the db: `, err)
 			}
 			return err
 		}
-		w.Key = meta.Key
+		writRedirects(w, "")
+		linkMedia(w)
 	} else {
 		if len(w.Key) == 0 {
 			w.Key = currentWrit.Key
+		} else if len(currentWrit.Key) == 0 {
+			currentWrit, err = WritByKey(w.Key)
+			if err != nil {
+				return ErrIncompleteWrit
+			}
+		}
+		// only edits move .EditRev on, so views and likes in between don't count as a conflict
+		if len(w.EditRev) != 0 && w.EditRev != currentWrit.EditRev {
+			return WritConflictError(&currentWrit)
+		}
+		if len(w.State) == 0 && w.Public == currentWrit.Public && len(currentWrit.State) != 0 {
+			// nothing about the workflow changed, so stay where we were
+			w.State = currentWrit.State
+			if w.PublishAt.IsZero() {
+				w.PublishAt = currentWrit.PublishAt
+			}
+		}
+		if err = w.syncState(); err != nil {
+			return err
+		}
+		if revisionWorthy(&currentWrit, w) {
+			err = SaveRevision(&currentWrit)
+			if err != nil && DevMode {
+				fmt.Println(`InitWrit - couldn't store the previous version as a revision: `, err)
+			}
 		}
 		if len(w.Title) != 0 && currentWrit.Title == w.Title {
 			w.Title = ""
@@ -594,53 +431,66 @@ func InitWrit(w *Writ) error {
 			w.Content = ""
 			w.Markdown = ""
 		} else {
+			if len(w.Author) == 0 {
+				// the policy the content's sanitized with depends on who wrote it
+				w.Author = currentWrit.Author
+			}
 			w.RenderContent()
 		}
 		if len(w.Edits) < len(currentWrit.Edits) {
 			w.Edits = append(w.Edits, currentWrit.Edits...)
 		}
 		w.Edits = append(w.Edits, time.Now())
-		ctx = driver.WithMergeObjects(ctx, true)
-		_, err := Writs.UpdateDocument(ctx, w.Key, w.ToObj("_key"))
+		editrev, err := Stores.Writs.Update(w.Key, w.EditRev, w.ToObj("_key"))
 		if err != nil {
+			if err == ErrStaleRevision {
+				// someone else got their save in between our read and write
+				latest, rerr := WritByKey(w.Key)
+				if rerr == nil {
+					return WritConflictError(&latest)
+				}
+			}
 			if DevMode {
 				fmt.Println(`InitWrit - error updating a writ in the db: `, err)
 			}
 			return err
 		}
-		if !currentWrit.Public && w.Public {
+		w.EditRev = editrev
+		writRedirects(w, currentWrit.Slug)
+		linkMedia(w)
+		if !currentWrit.Public && w.Public && claimNotification(w.Key) {
 			go notifySubscribers(w.Key)
 		}
 	}
 
+	if w.State == WritScheduled {
+		wakePublishScheduler()
+	}
+
+	writsChanged(w)
+	go refreshRelated(w.Key)
 	return nil
 }
 
+// writsChanged clear out everything that's cached and built from the writs that changed
+func writsChanged(changed ...*Writ) {
+	clearFeedCache()
+	clearSitemapCache()
+	for _, w := range changed {
+		forgetRelated(w.Key, w.Tags)
+	}
+}
+
 func notifySubscribers(writKey string) {
 	writ, err := WritByKey(writKey)
 	if err != nil {
 		return
 	}
 
-	query := `FOR u IN users FILTER u.subscriber == true RETURN u`
-	ctx := driver.WithQueryCount(context.Background())
-	var users []User
-	cursor, err := DB.Query(ctx, query, obj{})
+	users, err := Stores.Users.Subscribers()
 	if err != nil {
 		return
 	}
-	defer cursor.Close()
-	users = []User{}
-	for {
-		var doc User
-		_, err = cursor.ReadDocument(ctx, &doc)
-		if driver.IsNoMoreDocuments(err) {
-			break
-		} else if err != nil {
-			return
-		}
-		users = append(users, doc)
-	}
 
 	mail := MakeEmail()
 	mail.Subject("Subscriber Update: Newly Published Writ")
@@ -659,59 +509,140 @@ func notifySubscribers(writKey string) {
 	go SendEmail(mail)
 }
 
+// writPageData what the writ template gets to render a writ's page with
+func writPageData(writ *Writ, user *User) obj {
+	writdata := writ.ToObj()
+
+	writdata["Created"] = writ.Created.Format("1 Jan 2006")
+	writdata["CreateDate"] = writ.Created
+
+	editslen := len(writ.Edits)
+	if editslen != 0 {
+		writdata["ModifiedDate"] = writ.Edits[editslen-1]
+	}
+
+	writdata["URL"] = writ.GetLink()
+	writdata["OGImage"] = html.EscapeString(ogImageURL(writ))
+	writdata["OGImageWidth"] = OGImageWidth
+	writdata["OGImageHeight"] = OGImageHeight
+	writdata["OGTitle"] = html.EscapeString(writ.Title)
+	writdata["OGDescription"] = html.EscapeString(writ.Summary())
+	if validateInjection(writ.Injection) != nil {
+		// it was saved before injections were checked, or the allowed sources have changed since
+		delete(writdata, "injection")
+	}
+	if len(writ.TOC) != 0 {
+		toc := make([]obj, len(writ.TOC))
+		for i, entry := range writ.TOC {
+			toc[i] = obj{"Level": entry.Level, "ID": entry.ID, "Title": html.EscapeString(entry.Title)}
+		}
+		writdata["TOC"] = toc
+	}
+	writdata["Comments"] = writ.Comments
+	writdata["CommentCount"] = writ.CommentCount
+	if series := seriesPageData(writ, user); series != nil {
+		writdata["Series"] = series
+	}
+	if related := relatedPageData(writ); related != nil {
+		writdata["Related"] = related
+	}
+	return writdata
+}
+
+// tagPageData what the tag template gets to render a tag's page with
+func tagPageData(tag string, writs []Writ) obj {
+	list := make([]obj, 0, len(writs))
+	for _, writ := range writs {
+		writdata := writ.ToObj("content", "markdown")
+		writdata["Created"] = writ.Created.Format("1 Jan 2006")
+		writdata["URL"] = writ.GetLink()
+		writdata["Summary"] = html.EscapeString(writ.Summary())
+		list = append(list, writdata)
+	}
+
+	path := "/tag/" + url.PathEscape(tag)
+	return obj{
+		"Tag":     html.EscapeString(tag),
+		"AppName": AppName,
+		"URL":     siteURL() + path,
+		"Feed":    siteURL() + path + "/feed.xml",
+		"Writs":   list,
+	}
+}
+
 func initWrits() {
 	Server.GET("/writ/:slug", func(c ctx) error {
 		slug := c.Param("slug")
 
 		wq := WritQuery{
 			UpdateViews: true,
+			Comments:    true,
 			Slug:        slug,
 		}
 
 		user, err := CredentialCheck(c)
-		if err == nil {
-			wq.Viewer = user.Key
-			wq.IncludeMembersOnly = true
-		} else {
-			err = nil
+		if err != nil {
+			user = nil
 		}
+		wq.RestrictTo(user)
 
 		writ, err := wq.ExecOne()
 
-		if driver.IsNotFound(err) {
-			wq.Slug = ""
-			// incase the slug/title changed but the key stayed the same
-			key := c.Param("writ")
+		if isNotFound(err) {
+			// incase the slug/title changed but the key stayed the same,
+			// or it's an old link from before, send them to where the writ is now
+			key := c.QueryParam("writ")
 			if len(key) < 2 {
-				return NoSuchWrit.Send(c)
+				key, _ = Stores.Redirects.Lookup("/writ/" + slug)
+			}
+			if len(key) > 1 {
+				writ, err = redirectedWrit(key, user)
+				if err == nil {
+					return c.Redirect(301, writ.GetLink())
+				}
 			}
 
-			wq.Key = key
-			writ, err = wq.ExecOne()
-			if err != nil {
-				return Err404NotFound
+			if writAccessError(slug, key, user) == ForbiddenWrit {
+				return Err403Forbidden
 			}
+			return Err404NotFound
 		} else if err != nil {
 			return ServerDBError.SendJSON(c)
 		}
 
-		writdata := writ.ToObj()
-
-		writdata["Created"] = writ.Created.Format("1 Jan 2006")
-		writdata["CreateDate"] = writ.Created
+		err = c.Render(200, "writ", writPageData(&writ, user))
+		if err != nil {
+			if DevMode {
+				fmt.Println("GET /writ/:slug - error executing the post template: ", err)
+			}
+		}
+		return err
+	})
 
-		editslen := len(writ.Edits)
-		if editslen != 0 {
-			writdata["ModifiedDate"] = writ.Edits[editslen-1]
+	Server.GET("/tag/:tag", func(c ctx) error {
+		tag, err := url.PathUnescape(c.Param("tag"))
+		if err != nil || len(tag) < 1 {
+			return Err404NotFound
 		}
 
-		writdata["URL"] = writ.GetLink()
+		q := &WritQuery{
+			Limit: []int64{0, 200},
+			Tags:  []string{tag},
+		}
+		user, err := CredentialCheck(c)
+		if err != nil {
+			user = nil
+		}
+		q.RestrictTo(user)
 
-		err = c.Render(200, "writ", writdata)
+		writs, err := q.Exec()
 		if err != nil {
-			if DevMode {
-				fmt.Println("GET /writ/:slug - error executing the post template: ", err)
-			}
+			return ServerDBError.SendJSON(c)
+		}
+
+		err = c.Render(200, "tag", tagPageData(tag, writs))
+		if err != nil && DevMode {
+			fmt.Println("GET /tag/:tag - error executing the tag template: ", err)
 		}
 		return err
 	})
@@ -722,17 +653,7 @@ func initWrits() {
 			return BadRequestError.Send(c)
 		}
 
-		ctx := driver.WithKeepNull(driver.WithWaitForSync(driver.WithQueryCount(context.Background())), false)
-		_, err := DB.Query(
-			ctx,
-			`FOR w IN writs FILTER w.slug == @slug UPDATE w WITH {
-				 likedby: @user IN w.likedby ? REMOVE(w.likedby, @user) : PUSH(w.likedby, @user)
-			 } IN writs`,
-			obj{
-				"slug": slug,
-				"user": user.Key,
-			},
-		)
+		err := Stores.Writs.ToggleLike(slug, user.Key)
 		if err != nil {
 			return c.Msgpack(500, obj{
 				"err": "liking this writ failed somehow",
@@ -749,11 +670,11 @@ func initWrits() {
 		}
 
 		page, err := str2int64(c.Param("page"))
-		if err != nil {
+		if err != nil || page < 0 {
 			return BadRequestError.Send(c)
 		}
 		count, err := str2int64(c.Param("count"))
-		if err != nil {
+		if err != nil || count < 0 {
 			return BadRequestError.Send(c)
 		}
 
@@ -767,14 +688,13 @@ func initWrits() {
 		}
 
 		user, err := CredentialCheck(c)
-		if err == nil && user != nil {
-			if count > 200 {
-				return RequestQueryOverLimitMembers.Send(c)
+		if err != nil || user == nil {
+			if count > 50 {
+				return RequestQueryOverLimit.Send(c)
 			}
-			q.IncludeMembersOnly = true
-		} else if count > 50 {
-			return RequestQueryOverLimit.Send(c)
+			user = nil
 		}
+		q.RestrictTo(user)
 
 		writs, err := q.Exec()
 		if err != nil {
@@ -785,11 +705,11 @@ func initWrits() {
 
 	Server.GET("/writs/:page/:count", func(c ctx) error {
 		page, err := str2int64(c.Param("page"))
-		if err != nil {
+		if err != nil || page < 0 {
 			return BadRequestError.Send(c)
 		}
 		count, err := str2int64(c.Param("count"))
-		if err != nil {
+		if err != nil || count < 0 {
 			return BadRequestError.Send(c)
 		}
 
@@ -802,14 +722,13 @@ func initWrits() {
 		}
 
 		user, err := CredentialCheck(c)
-		if err == nil && user != nil {
-			if count > 200 {
-				return RequestQueryOverLimitMembers.Send(c)
+		if err != nil || user == nil {
+			if count > 50 {
+				return RequestQueryOverLimit.Send(c)
 			}
-			q.IncludeMembersOnly = true
-		} else if count > 50 {
-			return RequestQueryOverLimit.Send(c)
+			user = nil
 		}
+		q.RestrictTo(user)
 
 		writs, err := q.Exec()
 		if err != nil {
@@ -831,16 +750,20 @@ func initWrits() {
 		} else if len(writ.AuthorKey) == 0 {
 			writ.AuthorKey = user.Key
 		}
+		writ.Editor = user.Username
 
 		err = InitWrit(&writ)
 		if err != nil {
+			if cr, ok := err.(*CodedResponse); ok {
+				return cr.Send(c)
+			}
 			if !driver.IsNoMoreDocuments(err) {
 				return SendMsgpack(c, 503, obj{"ok": false, "error": err})
 			}
 		}
 
 		fmt.Println(`Baking Writs! - `, writ.Title)
-		return SuccessMsg.Send(c)
+		return c.Msgpack(203, obj{"msg": "success!", "_key": writ.Key, "editrev": writ.EditRev})
 	}))
 
 	Server.POST("/writ-query", AdminHandle(func(c ctx, user *User) error {
@@ -868,11 +791,37 @@ func initWrits() {
 			return BadRequestError.Send(c)
 		}
 
-		ctx := driver.WithWaitForSync(context.Background(), true)
-		_, err := Writs.RemoveDocument(ctx, key)
+		err := Stores.Writs.Remove(key)
 		if err != nil {
 			return DeleteWritError.Send(c)
 		}
+
+		writsChanged(&Writ{Key: key})
+
+		err = removeWritComments(key)
+		if err != nil && DevMode {
+			fmt.Println("GET /writ-delete/:key - couldn't remove the writ's comments: ", err)
+		}
+
+		err = removeWritRevisions(key)
+		if err != nil && DevMode {
+			fmt.Println("GET /writ-delete/:key - couldn't remove the writ's revisions: ", err)
+		}
+
+		err = Stores.Redirects.RemoveWrit(key)
+		if err != nil && DevMode {
+			fmt.Println("GET /writ-delete/:key - couldn't remove the writ's redirects: ", err)
+		}
+
+		err = Stores.Series.RemoveWrit(key)
+		if err != nil && DevMode {
+			fmt.Println("GET /writ-delete/:key - couldn't take the writ out of its series: ", err)
+		}
+
+		err = Stores.Media.SetWritRefs(key, nil)
+		if err != nil && DevMode {
+			fmt.Println("GET /writ-delete/:key - couldn't unlink the writ's media: ", err)
+		}
 		return c.Msgpack(200, obj{"msg": "writ deleted, it's gone"})
 	}))
 
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// setupTestStore point the stores at a fresh memory store with an author in it
+func setupTestStore(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := setupMemoryStore(filepath.Join(dir, "store.json")); err != nil {
+		t.Fatal(err)
+	}
+	Conf = &Config{Assets: filepath.Join(dir, "assets"), Media: "media"}
+	if err := Stores.Users.Create(&User{Username: "author", Email: "author@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// editedWrit make a writ and load it the way the editor would
+func editedWrit(t *testing.T) Writ {
+	t.Helper()
+	w := &Writ{Title: "Conflicts", Author: "author", Markdown: "first draft", Tags: []string{"test"}}
+	if err := InitWrit(w); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := WritByKey(w.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.EditRev) == 0 {
+		t.Fatal("a new writ doesn't have an edit revision")
+	}
+	return loaded
+}
+
+// save make an edit to the writ starting from the given edit revision
+func save(key, editrev, markdown string) error {
+	return InitWrit(&Writ{Key: key, EditRev: editrev, Markdown: markdown, Content: markdown, Tags: []string{"test"}})
+}
+
+func isConflict(err error) bool {
+	res, ok := err.(*CodedResponse)
+	return ok && res.Code == 409
+}
+
+func TestSaveCurrentEditRev(t *testing.T) {
+	setupTestStore(t)
+	w := editedWrit(t)
+	if err := save(w.Key, w.EditRev, "second draft"); err != nil {
+		t.Fatalf("saving from the current edit revision: %v", err)
+	}
+	saved, err := WritByKey(w.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Markdown != "second draft" {
+		t.Errorf("markdown is %q after saving, want %q", saved.Markdown, "second draft")
+	}
+	if saved.EditRev == w.EditRev {
+		t.Error("saving didn't move the edit revision on")
+	}
+}
+
+func TestSaveStaleEditRev(t *testing.T) {
+	setupTestStore(t)
+	w := editedWrit(t)
+	if err := save(w.Key, w.EditRev, "someone else's draft"); err != nil {
+		t.Fatal(err)
+	}
+	if err := save(w.Key, w.EditRev, "my draft"); !isConflict(err) {
+		t.Fatalf("saving from a stale edit revision: got %v, want a 409", err)
+	}
+	saved, err := WritByKey(w.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Markdown != "someone else's draft" {
+		t.Errorf("a rejected save changed the markdown to %q", saved.Markdown)
+	}
+}
+
+func TestSaveWithoutEditRev(t *testing.T) {
+	setupTestStore(t)
+	w := editedWrit(t)
+	if err := save(w.Key, w.EditRev, "someone else's draft"); err != nil {
+		t.Fatal(err)
+	}
+	if err := save(w.Key, "", "my draft"); err != nil {
+		t.Fatalf("saving without an edit revision: %v", err)
+	}
+	saved, err := WritByKey(w.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Markdown != "my draft" {
+		t.Errorf("markdown is %q after saving, want %q", saved.Markdown, "my draft")
+	}
+}
+
+func TestViewsDontConflict(t *testing.T) {
+	setupTestStore(t)
+	w := editedWrit(t)
+	if err := save(w.Key, w.EditRev, "published"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := WritByKey(w.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// someone reads and likes it while it's open in the editor
+	if _, err := Stores.Writs.QueryOne(&WritQuery{Slug: w.Slug, UpdateViews: true, IncludePrivate: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Stores.Writs.ToggleLike(w.Slug, "reader"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := save(w.Key, w.EditRev, "edited after a view"); err != nil {
+		t.Fatalf("saving after a view and a like: %v", err)
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/SaulDoesCode/anend
 
+go 1.18
+
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/CrowdSurge/banner v0.0.0-20140923200336-8c0e79dc5ff7
@@ -7,20 +9,39 @@ require (
 	github.com/SaulDoesCode/echo v0.0.0-20181116183411-2176afe2e938
 	github.com/SaulDoesCode/mailyak v0.0.0-20181107214438-034a561f5162
 	github.com/SaulDoesCode/transplacer v1.2.0
+	github.com/alecthomas/chroma v0.10.0
 	github.com/arangodb/go-driver v0.0.0-20181116121332-65af12d6f124
 	github.com/asaskevich/govalidator v0.0.0-20180720115003-f9ffefc3facf
-	github.com/cornelk/hashmap v1.0.0
 	github.com/driusan/dkim v0.0.0-20181021194700-dda260a68aa1
 	github.com/fsnotify/fsnotify v1.4.7
-	github.com/hashicorp/golang-lru v0.5.0 // indirect
 	github.com/integrii/flaggy v0.0.0-20181007032133-1056ce330646
 	github.com/json-iterator/go v1.1.5
-	github.com/labstack/gommon v0.2.8 // indirect
 	github.com/logrusorgru/aurora v0.0.0-20181002194514-a7b3b318ed4e
 	github.com/microcosm-cc/bluemonday v1.0.1
 	github.com/russross/blackfriday/v2 v2.0.1
-	github.com/shurcooL/sanitized_anchor_name v0.0.0-20170918181015-86672fcb3f95 // indirect
 	github.com/throttled/throttled v2.2.2+incompatible
 	github.com/vmihailenco/msgpack v4.0.1+incompatible
-	golang.org/x/crypto v0.0.0-20181106171534-e4dc69e5b2fd
+	golang.org/x/crypto v0.23.0
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.25.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/arangodb/go-velocypack v0.0.0-20180928134037-d177e3455691 // indirect
+	github.com/cespare/xxhash v1.1.0 // indirect
+	github.com/cornelk/hashmap v1.0.0 // indirect
+	github.com/dchest/siphash v1.2.0 // indirect
+	github.com/dlclark/regexp2 v1.4.0 // indirect
+	github.com/hashicorp/golang-lru v0.5.0 // indirect
+	github.com/labstack/gommon v0.2.8 // indirect
+	github.com/mattn/go-colorable v0.0.9 // indirect
+	github.com/mattn/go-isatty v0.0.4 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
+	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/shurcooL/sanitized_anchor_name v0.0.0-20170918181015-86672fcb3f95 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasttemplate v0.0.0-20170224212429-dcecefd839c4 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 )